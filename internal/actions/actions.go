@@ -0,0 +1,140 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+	"github.com/doodlescheduling/gh-package-retention/internal/report"
+)
+
+// Enabled returns true if running within Github Actions.
+func Enabled() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// Publish appends the job summary to $GITHUB_STEP_SUMMARY and the step outputs to $GITHUB_OUTPUT.
+func Publish(dryRun bool, evaluations []*ghpackage.Evaluation) error {
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		if err := appendFile(path, func(w io.Writer) error {
+			return WriteSummary(w, dryRun, evaluations)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if path := os.Getenv("GITHUB_OUTPUT"); path != "" {
+		if err := appendFile(path, func(w io.Writer) error {
+			return WriteOutputs(w, evaluations)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteSummary writes a markdown summary of all package versions which are (or would be) deleted.
+func WriteSummary(w io.Writer, dryRun bool, evaluations []*ghpackage.Evaluation) error {
+	counts := count(evaluations)
+
+	title := "## Package retention"
+	if dryRun {
+		title += " (dry-run)"
+	}
+
+	if _, err := fmt.Fprintf(w, "%s\n\n", title); err != nil {
+		return err
+	}
+
+	if dryRun {
+		if _, err := fmt.Fprint(w, "> [!NOTE]\n> This was a dry-run, no package versions have been deleted.\n\n"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "| evaluated | kept | deleted | would-delete | error |\n| --- | --- | --- | --- | --- |\n| %d | %d | %d | %d | %d |\n\n",
+		len(evaluations),
+		counts[ghpackage.DecisionKept],
+		counts[ghpackage.DecisionDeleted],
+		counts[ghpackage.DecisionWouldDelete],
+		counts[ghpackage.DecisionError],
+	); err != nil {
+		return err
+	}
+
+	var changed []*ghpackage.Evaluation
+	for _, evaluation := range evaluations {
+		if evaluation.Decision != ghpackage.DecisionKept {
+			changed = append(changed, evaluation)
+		}
+	}
+
+	if len(changed) == 0 {
+		_, err := fmt.Fprint(w, "No package versions elected for deletion.\n")
+		return err
+	}
+
+	return report.Write(w, report.FormatMarkdown, changed)
+}
+
+// WriteOutputs writes the step outputs in the $GITHUB_OUTPUT format.
+func WriteOutputs(w io.Writer, evaluations []*ghpackage.Evaluation) error {
+	counts := count(evaluations)
+	deleted, err := versions(evaluations, ghpackage.DecisionDeleted)
+	if err != nil {
+		return err
+	}
+
+	wouldDelete, err := versions(evaluations, ghpackage.DecisionWouldDelete)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "deleted-count=%d\nwould-delete-count=%d\nerror-count=%d\ndeleted-versions=%s\nwould-delete-versions=%s\n",
+		counts[ghpackage.DecisionDeleted],
+		counts[ghpackage.DecisionWouldDelete],
+		counts[ghpackage.DecisionError],
+		deleted,
+		wouldDelete,
+	)
+
+	return err
+}
+
+func count(evaluations []*ghpackage.Evaluation) map[ghpackage.Decision]int {
+	counts := make(map[ghpackage.Decision]int)
+	for _, evaluation := range evaluations {
+		counts[evaluation.Decision]++
+	}
+
+	return counts
+}
+
+// versions returns the evaluations with the given decision as single line JSON.
+func versions(evaluations []*ghpackage.Evaluation, decision ghpackage.Decision) ([]byte, error) {
+	matching := []*ghpackage.Evaluation{}
+	for _, evaluation := range report.Sort(evaluations) {
+		if evaluation.Decision == decision {
+			matching = append(matching, evaluation)
+		}
+	}
+
+	return json.Marshal(matching)
+}
+
+func appendFile(path string, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,95 @@
+package actions
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+	"github.com/stretchr/testify/assert"
+)
+
+var evaluations = []*ghpackage.Evaluation{
+	{
+		Owner:       "myorg",
+		OwnerType:   "organization",
+		PackageType: "maven",
+		PackageName: "mypackage",
+		Version:     "1.0.0",
+		ID:          1,
+		Decision:    ghpackage.DecisionKept,
+		Rule:        ghpackage.RuleProtectMatch,
+	},
+	{
+		Owner:       "myorg",
+		OwnerType:   "organization",
+		PackageType: "maven",
+		PackageName: "mypackage",
+		Version:     "1.0.0-SNAPSHOT",
+		ID:          2,
+		Decision:    ghpackage.DecisionDeleted,
+		Rule:        ghpackage.RuleAge,
+	},
+}
+
+func TestWriteSummary(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, WriteSummary(&b, false, evaluations))
+	assert.Equal(t, `## Package retention
+
+| evaluated | kept | deleted | would-delete | error |
+| --- | --- | --- | --- | --- |
+| 2 | 1 | 1 | 0 | 0 |
+
+| owner | package_type | package | version | tags | id | updated_at | decision | rule | error |
+| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |
+| myorg | maven | mypackage | 1.0.0-SNAPSHOT |  | 2 |  | deleted | age |  |
+`, b.String())
+}
+
+func TestWriteSummaryDryRun(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, WriteSummary(&b, true, evaluations[:1]))
+	assert.Equal(t, `## Package retention (dry-run)
+
+> [!NOTE]
+> This was a dry-run, no package versions have been deleted.
+
+| evaluated | kept | deleted | would-delete | error |
+| --- | --- | --- | --- | --- |
+| 1 | 1 | 0 | 0 | 0 |
+
+No package versions elected for deletion.
+`, b.String())
+}
+
+func TestWriteOutputs(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, WriteOutputs(&b, evaluations))
+	assert.Equal(t, `deleted-count=1
+would-delete-count=0
+error-count=0
+deleted-versions=[{"owner":"myorg","ownerType":"organization","packageType":"maven","package":"mypackage","version":"1.0.0-SNAPSHOT","id":2,"updatedAt":"0001-01-01T00:00:00Z","decision":"deleted","rule":"age"}]
+would-delete-versions=[]
+`, b.String())
+}
+
+func TestPublish(t *testing.T) {
+	dir := t.TempDir()
+	summary := filepath.Join(dir, "summary")
+	output := filepath.Join(dir, "output")
+	assert.NoError(t, os.WriteFile(output, []byte("existing=1\n"), 0644))
+
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+	t.Setenv("GITHUB_OUTPUT", output)
+	assert.NoError(t, Publish(true, evaluations))
+
+	b, err := os.ReadFile(summary)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "## Package retention (dry-run)")
+
+	b, err = os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "existing=1\ndeleted-count=1\n")
+}
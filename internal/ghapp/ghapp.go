@@ -0,0 +1,165 @@
+package ghapp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v53/github"
+	"golang.org/x/oauth2"
+)
+
+// refreshBefore is how long before its expiry an installation token is refreshed.
+const refreshBefore = 5 * time.Minute
+
+// Config describes a GitHub App installation.
+type Config struct {
+	AppID          int64
+	InstallationID int64
+	// PrivateKey is the PEM encoded private key of the GitHub App.
+	PrivateKey []byte
+	// BaseURL of a GitHub Enterprise Server API (/api/v3/ is appended if missing), defaults to https://api.github.com/.
+	BaseURL string
+	// Transport used to request installation tokens, defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// TokenSource returns a token source for installation tokens of the GitHub App.
+// Installation tokens are cached and refreshed shortly before they expire.
+func (c Config) TokenSource() (oauth2.TokenSource, error) {
+	if c.AppID == 0 || c.InstallationID == 0 {
+		return nil, errors.New("app id and installation id are required")
+	}
+
+	key, err := parsePrivateKey(c.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	httpClient := &http.Client{
+		Transport: &jwtTransport{
+			appID: c.AppID,
+			key:   key,
+			next:  transport,
+		},
+	}
+
+	client := github.NewClient(httpClient)
+	if c.BaseURL != "" {
+		client, err = github.NewEnterpriseClient(c.BaseURL, c.BaseURL, httpClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return oauth2.ReuseTokenSourceWithExpiry(nil, &installationTokenSource{
+		installationID: c.InstallationID,
+		client:         client,
+	}, refreshBefore), nil
+}
+
+type installationTokenSource struct {
+	installationID int64
+	client         *github.Client
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	token, _, err := s.client.Apps.CreateInstallationToken(context.Background(), s.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create installation token: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		Expiry:      token.GetExpiresAt().Time,
+	}, nil
+}
+
+// jwtTransport authenticates requests as the GitHub App itself.
+type jwtTransport struct {
+	appID int64
+	key   *rsa.PrivateKey
+	next  http.RoundTripper
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := signJWT(t.appID, t.key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
+
+// signJWT creates a RS256 signed JWT for the GitHub App which is valid for 10 minutes.
+// The issued at time is set in the past to allow for clock drift.
+func signJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS1 or PKCS8 RSA private key.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not a RSA key")
+	}
+
+	return rsaKey, nil
+}
@@ -0,0 +1,107 @@
+package ghapp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v3/app/installations/42/access_tokens", r.URL.Path)
+
+		claims := verifyJWT(t, &key.PublicKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		assert.Equal(t, "1", claims["iss"])
+
+		// The first token expires within the refresh window and is refreshed on the next use
+		expiresAt := time.Now().Add(time.Minute)
+		if requests > 1 {
+			expiresAt = time.Now().Add(time.Hour)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token":"token-%d","expires_at":%q}`, requests, expiresAt.Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	for _, block := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, key)},
+	} {
+		requests = 0
+		ts, err := Config{
+			AppID:          1,
+			InstallationID: 42,
+			PrivateKey:     pem.EncodeToMemory(block),
+			BaseURL:        server.URL,
+		}.TokenSource()
+		assert.NoError(t, err)
+
+		for _, expected := range []string{"token-1", "token-2", "token-2"} {
+			token, err := ts.Token()
+			assert.NoError(t, err)
+			assert.Equal(t, expected, token.AccessToken)
+		}
+
+		assert.Equal(t, 2, requests)
+	}
+}
+
+func TestTokenSourceInvalidConfig(t *testing.T) {
+	_, err := Config{AppID: 1, InstallationID: 42, PrivateKey: []byte("no pem")}.TokenSource()
+	assert.Error(t, err)
+
+	_, err = Config{AppID: 1}.TokenSource()
+	assert.Error(t, err)
+}
+
+func verifyJWT(t *testing.T, key *rsa.PublicKey, token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	if !assert.Len(t, parts, 3) {
+		return nil
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err)
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature))
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err)
+
+	claims := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(payload, &claims))
+
+	now := float64(time.Now().Unix())
+	assert.Less(t, claims["iat"], now)
+	assert.Greater(t, claims["exp"], now)
+	assert.LessOrEqual(t, claims["exp"].(float64)-claims["iat"].(float64), float64(600))
+
+	return claims
+}
+
+func mustMarshalPKCS8(t *testing.T, key *rsa.PrivateKey) []byte {
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	return b
+}
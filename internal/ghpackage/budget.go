@@ -0,0 +1,48 @@
+package ghpackage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDeletionBudgetExceeded is returned if more package versions would be deleted than allowed.
+var ErrDeletionBudgetExceeded = errors.New("deletion budget exceeded")
+
+// checkBudget verifies that a package does not lose more versions than allowed by
+// MaxPackageDeletions and MaxDeletePercent. The budget is ignored if Force is set.
+func (a *RetentionManager) checkBudget(packageName string, deletions, versions int) error {
+	var err error
+	switch {
+	case a.MaxPackageDeletions > 0 && deletions > a.MaxPackageDeletions:
+		err = fmt.Errorf("%w: %d versions would be deleted, max-package-deletions is %d", ErrDeletionBudgetExceeded, deletions, a.MaxPackageDeletions)
+	case a.MaxDeletePercent > 0 && versions > 0 && float64(deletions)/float64(versions)*100 > a.MaxDeletePercent:
+		err = fmt.Errorf("%w: %d of %d versions (%.1f%%) would be deleted, max-delete-percent is %g%%", ErrDeletionBudgetExceeded, deletions, versions, float64(deletions)/float64(versions)*100, a.MaxDeletePercent)
+	}
+
+	return a.exceeded(err, "package", packageName)
+}
+
+// checkRunBudget verifies that a run does not delete more versions than allowed by MaxDeletions.
+// If a run spans multiple managers the elected versions of all managers are checked together.
+// The budget is ignored if Force is set.
+func (a *RetentionManager) checkRunBudget(deletions int) error {
+	var err error
+	if a.MaxDeletions > 0 && deletions > a.MaxDeletions {
+		err = fmt.Errorf("%w: %d versions would be deleted, max-deletions is %d", ErrDeletionBudgetExceeded, deletions, a.MaxDeletions)
+	}
+
+	return a.exceeded(err, "deletions", deletions)
+}
+
+func (a *RetentionManager) exceeded(err error, keysAndValues ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	if a.Force {
+		a.Logger.Info("ignore exceeded deletion budget as force is set", append(keysAndValues, "reason", err.Error())...)
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,211 @@
+package ghpackage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDeletionBudget(t *testing.T) {
+	var tests = []struct {
+		name             string
+		RetentionManager *RetentionManager
+		expectErr        bool
+		expected         []int64
+	}{
+		{
+			name:             "Package versions within all budgets are deleted",
+			RetentionManager: &RetentionManager{MaxDeletions: 6, MaxPackageDeletions: 3, MaxDeletePercent: 75},
+			expected:         []int64{101, 102, 103, 201, 202, 203},
+		},
+		{
+			name:             "A package which exceeds max-package-deletions is not deleted",
+			RetentionManager: &RetentionManager{MaxPackageDeletions: 2},
+			expectErr:        true,
+		},
+		{
+			name:             "A package which exceeds max-delete-percent is not deleted",
+			RetentionManager: &RetentionManager{MaxDeletePercent: 50},
+			expectErr:        true,
+		},
+		{
+			name:             "Other packages are deleted in continue-on-error mode",
+			RetentionManager: &RetentionManager{MaxPackageDeletions: 2, ContinueOnError: true, PackageNames: []string{"package-1", "package-2", "package-3"}},
+			expectErr:        true,
+			expected:         []int64{302, 303},
+		},
+		{
+			name:             "Nothing is deleted if the run exceeds max-deletions",
+			RetentionManager: &RetentionManager{MaxDeletions: 5},
+			expectErr:        true,
+		},
+		{
+			name:             "Budgets are ignored with force",
+			RetentionManager: &RetentionManager{MaxDeletions: 1, MaxPackageDeletions: 1, MaxDeletePercent: 1, Force: true},
+			expected:         []int64{101, 102, 103, 201, 202, 203},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var deleteCalls int
+
+			a := test.RetentionManager
+			if a.PackageNames == nil {
+				a.PackageNames = []string{"package-1", "package-2"}
+			}
+
+			a.PackageType = "maven"
+			a.OrganizationName = "myorg"
+			a.Age = time.Minute
+			a.Logger = logr.Discard()
+			a.GithubClient = github.NewClient(mock.NewMockedHTTPClient(
+				mock.WithRequestMatchHandler(
+					mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						// package-1 and package-2 have 3 old and 1 new version, package-3 has 2 old and 2 new versions
+						var p int64
+						_, _ = fmt.Sscanf(strings.Split(r.URL.Path, "/")[5], "package-%d", &p)
+
+						var versions []*github.PackageVersion
+						for i := int64(0); i < 4; i++ {
+							updatedAt := time.Now().Add(-time.Duration(i) * time.Hour)
+							if p == 3 && i == 1 {
+								updatedAt = time.Now()
+							}
+
+							versions = append(versions, &github.PackageVersion{
+								Name:      github.String(fmt.Sprintf("%d.0.0", i)),
+								ID:        github.Int64(p*100 + i),
+								UpdatedAt: &github.Timestamp{Time: updatedAt},
+							})
+						}
+
+						_, _ = w.Write(mock.MustMarshal(versions))
+					}),
+				),
+				mock.WithRequestMatchHandler(
+					mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						deleteCalls++
+						w.WriteHeader(http.StatusNoContent)
+					}),
+				),
+			))
+
+			removed, err := a.Run(context.TODO())
+			if test.expectErr {
+				assert.ErrorIs(t, err, ErrDeletionBudgetExceeded)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			var ids []int64
+			for _, packageVersion := range removed {
+				ids = append(ids, packageVersion.ID)
+			}
+
+			assert.Equal(t, test.expected, ids)
+			assert.Equal(t, len(test.expected), deleteCalls)
+
+			for _, evaluation := range a.Evaluations() {
+				assert.NotEqual(t, DecisionWouldDelete, evaluation.Decision)
+				if evaluation.Decision == DecisionKept && evaluation.Rule != RuleAge {
+					assert.Equal(t, RuleDeletionBudget, evaluation.Rule)
+				}
+			}
+		})
+	}
+}
+
+func TestRunAllDeletionBudget(t *testing.T) {
+	var tests = []struct {
+		name         string
+		maxDeletions int
+		expectErr    bool
+		expected     int
+	}{
+		{
+			name:         "Managers within max-deletions are deleted",
+			maxDeletions: 6,
+			expected:     6,
+		},
+		{
+			name:         "Nothing is deleted if all managers together exceed max-deletions",
+			maxDeletions: 5,
+			expectErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var deleteCalls int
+
+			// Every manager elects the 3 old versions of its package
+			manager := func(packageName string) *RetentionManager {
+				return &RetentionManager{
+					PackageNames:     []string{packageName},
+					PackageType:      "maven",
+					OrganizationName: "myorg",
+					Age:              time.Minute,
+					Logger:           logr.Discard(),
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatchHandler(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+								var versions []*github.PackageVersion
+								for i := int64(1); i <= 3; i++ {
+									versions = append(versions, &github.PackageVersion{
+										Name:      github.String(fmt.Sprintf("%d.0.0", i)),
+										ID:        github.Int64(i),
+										UpdatedAt: &github.Timestamp{Time: time.Now().Add(-time.Duration(i) * time.Hour)},
+									})
+								}
+
+								_, _ = w.Write(mock.MustMarshal(versions))
+							}),
+						),
+						mock.WithRequestMatchHandler(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+								deleteCalls++
+								w.WriteHeader(http.StatusNoContent)
+							}),
+						),
+					)),
+				}
+			}
+
+			base := &RetentionManager{MaxDeletions: test.maxDeletions, Logger: logr.Discard()}
+			managers := []*RetentionManager{manager("package-1"), manager("package-2")}
+
+			removed, failures := RunAll(context.TODO(), base, managers, func(a *RetentionManager) ([]*PackageVersion, error) {
+				return a.Evaluate(context.TODO())
+			})
+
+			if test.expectErr {
+				assert.Len(t, failures, 1)
+				assert.ErrorIs(t, failures[0], ErrDeletionBudgetExceeded)
+			} else {
+				assert.Empty(t, failures)
+			}
+
+			assert.Len(t, removed, test.expected)
+			assert.Equal(t, test.expected, deleteCalls)
+
+			for _, a := range managers {
+				for _, evaluation := range a.Evaluations() {
+					assert.NotEqual(t, DecisionWouldDelete, evaluation.Decision)
+				}
+			}
+		})
+	}
+}
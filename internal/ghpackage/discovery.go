@@ -0,0 +1,128 @@
+package ghpackage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v53/github"
+)
+
+// discoverPackages lists all packages of PackageType owned by the package owner
+// which match PackageMatch and Repository.
+func (a *RetentionManager) discoverPackages(ctx context.Context) ([]string, error) {
+	var packageNames []string
+	opts := &github.PackageListOptions{
+		PackageType: &a.PackageType,
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+
+	for {
+		var (
+			packages []*github.Package
+			resp     *github.Response
+		)
+
+		err := a.retry(ctx, "list packages", func() (*github.Response, error) {
+			var err error
+			if a.ownedByOrganization() {
+				packages, resp, err = a.GithubClient.Organizations.ListPackages(ctx, a.OrganizationName, opts)
+			} else {
+				packages, resp, err = a.GithubClient.Users.ListPackages(ctx, a.UserName, opts)
+			}
+
+			return resp, err
+		})
+
+		if err != nil {
+			return packageNames, err
+		}
+
+		for _, pkg := range packages {
+			if !a.matchPackage(pkg) {
+				a.Logger.V(1).Info("skip package as it does not match the package filters", "package", pkg.GetName())
+				continue
+			}
+
+			if pkg.VersionCount != nil && *pkg.VersionCount == 0 {
+				a.Logger.V(1).Info("skip package as it has no versions", "package", pkg.GetName())
+				continue
+			}
+
+			a.Logger.Info("discovered package", "package", pkg.GetName(), "repository", pkg.GetRepository().GetFullName())
+			packageNames = append(packageNames, pkg.GetName())
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+
+		opts.Page = resp.NextPage
+	}
+
+	return packageNames, nil
+}
+
+func (a *RetentionManager) matchPackage(pkg *github.Package) bool {
+	if a.PackageMatch != nil && !a.PackageMatch.MatchString(pkg.GetName()) {
+		return false
+	}
+
+	if a.Repository != "" {
+		repository := pkg.GetRepository()
+		if !strings.EqualFold(repository.GetName(), a.Repository) && !strings.EqualFold(repository.GetFullName(), a.Repository) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// packageNames returns PackageNames together with the discovered packages if AllPackages is enabled.
+// The returned map contains the names of the discovered packages.
+func (a *RetentionManager) packageNames(ctx context.Context) ([]string, map[string]struct{}, error) {
+	discovered := make(map[string]struct{})
+	if !a.AllPackages {
+		return a.PackageNames, discovered, nil
+	}
+
+	packageNames := append([]string{}, a.PackageNames...)
+	names, err := a.discoverPackages(ctx)
+	if err != nil {
+		return packageNames, discovered, err
+	}
+
+	for _, name := range names {
+		if contains(packageNames, name) {
+			continue
+		}
+
+		discovered[name] = struct{}{}
+		packageNames = append(packageNames, name)
+	}
+
+	return packageNames, discovered, nil
+}
+
+// isAccessError returns true if the error results from a package which is not accessible.
+func isAccessError(err error) bool {
+	var errResponse *github.ErrorResponse
+	if !errors.As(err, &errResponse) || errResponse.Response == nil {
+		return false
+	}
+
+	return errResponse.Response.StatusCode == http.StatusForbidden || errResponse.Response.StatusCode == http.StatusNotFound
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
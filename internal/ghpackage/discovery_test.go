@@ -0,0 +1,131 @@
+package ghpackage
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverPackages(t *testing.T) {
+	var tests = []struct {
+		name             string
+		RetentionManager *RetentionManager
+		expected         []string
+	}{
+		{
+			name:             "All packages with versions are discovered",
+			RetentionManager: &RetentionManager{},
+			expected:         []string{"app", "app-chart", "other"},
+		},
+		{
+			name: "Packages are filtered by PackageMatch",
+			RetentionManager: &RetentionManager{
+				PackageMatch: regexp.MustCompile(`^app`),
+			},
+			expected: []string{"app", "app-chart"},
+		},
+		{
+			name: "Packages are filtered by Repository",
+			RetentionManager: &RetentionManager{
+				Repository: "myorg/app",
+			},
+			expected: []string{"app", "app-chart"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := test.RetentionManager
+			a.Logger = logr.Discard()
+			a.OrganizationName = "myorg"
+			a.PackageType = "container"
+			a.GithubClient = github.NewClient(mock.NewMockedHTTPClient(
+				mock.WithRequestMatch(
+					mock.GetOrgsPackagesByOrg,
+					[]*github.Package{
+						newPackage("app", "myorg/app", 10),
+						newPackage("app-chart", "myorg/app", 1),
+						newPackage("empty", "myorg/app", 0),
+						newPackage("other", "myorg/other", 5),
+					},
+				),
+			))
+
+			packageNames, err := a.discoverPackages(context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, packageNames)
+		})
+	}
+}
+
+func TestRunSkipsInaccessibleDiscoveredPackages(t *testing.T) {
+	var (
+		packageName1       = "package-1"
+		packageID1   int64 = 1
+	)
+
+	a := &RetentionManager{
+		AllPackages:      true,
+		PackageType:      "maven",
+		OrganizationName: "myorg",
+		Age:              time.Second * 10,
+		Logger:           logr.Discard(),
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(
+				mock.GetOrgsPackagesByOrg,
+				[]*github.Package{
+					newPackage("forbidden", "myorg/app", 1),
+					newPackage("mypackage", "myorg/app", 1),
+				},
+			),
+			mock.WithRequestMatchHandler(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/orgs/myorg/packages/maven/forbidden/versions" {
+						mock.WriteError(w, http.StatusForbidden, "forbidden")
+						return
+					}
+
+					_, _ = w.Write(mock.MustMarshal([]*github.PackageVersion{
+						{
+							Name:      &packageName1,
+							ID:        &packageID1,
+							UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+						},
+					}))
+				}),
+			),
+			mock.WithRequestMatch(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				nil,
+			),
+		)),
+	}
+
+	removed, err := a.Run(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []*PackageVersion{
+		{
+			PackageName: "mypackage",
+			Version:     "package-1",
+			ID:          1,
+		},
+	}, removed)
+}
+
+func newPackage(name, repository string, versionCount int64) *github.Package {
+	return &github.Package{
+		Name:         &name,
+		VersionCount: &versionCount,
+		Repository: &github.Repository{
+			FullName: &repository,
+		},
+	}
+}
@@ -0,0 +1,182 @@
+package ghpackage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v53/github"
+)
+
+// Decision is the outcome for an evaluated package version.
+type Decision string
+
+const (
+	DecisionKept        Decision = "kept"
+	DecisionDeleted     Decision = "deleted"
+	DecisionWouldDelete Decision = "would-delete"
+	DecisionError       Decision = "error"
+)
+
+// Rule is the retention rule which decided about a package version.
+type Rule string
+
+const (
+	// Rules which keep a version
+	RuleProtectMatch   Rule = "protect-match"
+	RuleInUse          Rule = "in-use"
+	RuleKeepLast       Rule = "keep-last"
+	RuleSemver         Rule = "semver"
+	RuleNoTimestamp    Rule = "no-timestamp"
+	RuleReferenced     Rule = "referenced"
+	RuleAttached       Rule = "attached"
+	RulePlanChanged    Rule = "plan-changed"
+	RuleMaxVersions    Rule = "max-versions"
+	RuleDeletionBudget Rule = "deletion-budget"
+
+	// Rules which either keep or elect a version
+	RuleAge          Rule = "age"
+	RuleVersionMatch Rule = "version-match"
+	RuleUntagged     Rule = "untagged"
+
+	// Rules which elect a version
+	RuleAll       Rule = "all"
+	RuleIndex     Rule = "index"
+	RuleCompanion Rule = "companion"
+	RuleReferrer  Rule = "referrer"
+)
+
+// Evaluation is the decision made for a single package version.
+type Evaluation struct {
+	Owner       string    `json:"owner"`
+	OwnerType   string    `json:"ownerType"`
+	PackageType string    `json:"packageType"`
+	PackageName string    `json:"package"`
+	Version     string    `json:"version"`
+	Tags        []string  `json:"tags,omitempty"`
+	ID          int64     `json:"id"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Decision    Decision  `json:"decision"`
+	Rule        Rule      `json:"rule"`
+	Error       string    `json:"error,omitempty"`
+	reclaimed   int64
+}
+
+type evaluationLog struct {
+	mu    sync.Mutex
+	list  []*Evaluation
+	index map[int64]*Evaluation
+}
+
+func newEvaluationLog() *evaluationLog {
+	return &evaluationLog{
+		index: make(map[int64]*Evaluation),
+	}
+}
+
+// Evaluations returns the decisions made for all evaluated package versions in the order they were evaluated.
+func (a *RetentionManager) Evaluations() []*Evaluation {
+	if a.evaluations == nil {
+		return nil
+	}
+
+	a.evaluations.mu.Lock()
+	defer a.evaluations.mu.Unlock()
+
+	return append([]*Evaluation{}, a.evaluations.list...)
+}
+
+func (a *RetentionManager) record(packageName string, version *github.PackageVersion, decision Decision, rule Rule) {
+	a.evaluations.mu.Lock()
+	defer a.evaluations.mu.Unlock()
+
+	evaluation, ok := a.evaluations.index[*version.ID]
+	if !ok {
+		evaluation = &Evaluation{
+			Owner:       a.owner,
+			OwnerType:   a.ownerType(),
+			PackageType: a.PackageType,
+			PackageName: packageName,
+			Version:     *version.Name,
+			Tags:        tagsOf(version),
+			ID:          *version.ID,
+		}
+
+		if version.UpdatedAt != nil {
+			evaluation.UpdatedAt = version.UpdatedAt.Time
+		}
+
+		a.evaluations.index[*version.ID] = evaluation
+		a.evaluations.list = append(a.evaluations.list, evaluation)
+	}
+
+	evaluation.Decision = decision
+	evaluation.Rule = rule
+}
+
+func (a *RetentionManager) keep(packageName string, version *github.PackageVersion, rule Rule) {
+	a.record(packageName, version, DecisionKept, rule)
+}
+
+// recordDeletion updates the decision of an elected package version once it has been deleted.
+// The size is the number of bytes reclaimed by the deletion.
+func (a *RetentionManager) recordDeletion(packageVersion *PackageVersion, size int64, err error) {
+	a.evaluations.mu.Lock()
+	defer a.evaluations.mu.Unlock()
+
+	evaluation, ok := a.evaluations.index[packageVersion.ID]
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		evaluation.Decision = DecisionError
+		evaluation.Error = err.Error()
+		return
+	}
+
+	evaluation.Decision = DecisionDeleted
+	evaluation.reclaimed = size
+}
+
+// recordFailure records a package which could not be evaluated.
+func (a *RetentionManager) recordFailure(packageName string, err error) {
+	a.evaluations.mu.Lock()
+	defer a.evaluations.mu.Unlock()
+
+	a.evaluations.list = append(a.evaluations.list, &Evaluation{
+		Owner:       a.owner,
+		OwnerType:   a.ownerType(),
+		PackageType: a.PackageType,
+		PackageName: packageName,
+		Decision:    DecisionError,
+		Error:       err.Error(),
+	})
+}
+
+// updatedAt returns the last update of the evaluated package versions by their ID.
+func (a *RetentionManager) updatedAt(packageVersions []*PackageVersion) map[int64]time.Time {
+	a.evaluations.mu.Lock()
+	defer a.evaluations.mu.Unlock()
+
+	updated := make(map[int64]time.Time, len(packageVersions))
+	for _, packageVersion := range packageVersions {
+		if evaluation, ok := a.evaluations.index[packageVersion.ID]; ok {
+			updated[packageVersion.ID] = evaluation.UpdatedAt
+		}
+	}
+
+	return updated
+}
+
+// keepElected reverts the decision of elected package versions which are not going to be deleted.
+func (a *RetentionManager) keepElected(packageVersions []*PackageVersion, rule Rule) {
+	a.evaluations.mu.Lock()
+	defer a.evaluations.mu.Unlock()
+
+	for _, packageVersion := range packageVersions {
+		if evaluation, ok := a.evaluations.index[packageVersion.ID]; ok {
+			evaluation.Decision = DecisionKept
+			evaluation.Rule = rule
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ghpackage
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluations(t *testing.T) {
+	var tests = []struct {
+		name     string
+		dryRun   bool
+		expected []Decision
+	}{
+		{
+			name:     "Elected versions are reported as would-delete in dry-run",
+			dryRun:   true,
+			expected: []Decision{DecisionWouldDelete, DecisionKept, DecisionKept},
+		},
+		{
+			name:     "Elected versions are reported as deleted",
+			dryRun:   false,
+			expected: []Decision{DecisionDeleted, DecisionKept, DecisionKept},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				packageName1       = "1.0.0-SNAPSHOT"
+				packageID1   int64 = 1
+				packageName2       = "1.1.0-SNAPSHOT"
+				packageID2   int64 = 2
+				packageName3       = "1.0.0"
+				packageID3   int64 = 3
+				updatedAt          = time.Now().Add(-60 * time.Second)
+			)
+
+			a := &RetentionManager{
+				PackageNames:     []string{"mypackage"},
+				PackageType:      "maven",
+				Age:              time.Second * 10,
+				ProtectMatch:     regexp.MustCompile(`^[0-9.]+$`),
+				OrganizationName: "myorg",
+				DryRun:           test.dryRun,
+				Logger:           logr.Discard(),
+				GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+					mock.WithRequestMatch(
+						mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+						[]*github.PackageVersion{
+							{
+								Name:      &packageName1,
+								ID:        &packageID1,
+								UpdatedAt: &github.Timestamp{Time: updatedAt},
+							},
+							{
+								Name:      &packageName2,
+								ID:        &packageID2,
+								UpdatedAt: &github.Timestamp{Time: time.Now()},
+							},
+							{
+								Name:      &packageName3,
+								ID:        &packageID3,
+								UpdatedAt: &github.Timestamp{Time: updatedAt},
+							},
+						},
+					),
+					mock.WithRequestMatch(
+						mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+						nil,
+					),
+				)),
+			}
+
+			_, err := a.Run(context.TODO())
+			assert.NoError(t, err)
+
+			evaluations := a.Evaluations()
+			var decisions []Decision
+			for _, evaluation := range evaluations {
+				decisions = append(decisions, evaluation.Decision)
+			}
+
+			assert.Equal(t, test.expected, decisions)
+
+			evaluation := *evaluations[0]
+			assert.True(t, updatedAt.Equal(evaluation.UpdatedAt))
+			evaluation.UpdatedAt = updatedAt
+
+			assert.Equal(t, Evaluation{
+				Owner:       "myorg",
+				OwnerType:   OwnerTypeOrganization,
+				PackageType: "maven",
+				PackageName: "mypackage",
+				Version:     packageName1,
+				ID:          1,
+				UpdatedAt:   updatedAt,
+				Decision:    test.expected[0],
+				Rule:        RuleAge,
+			}, evaluation)
+			// Versions are evaluated oldest first
+			assert.Equal(t, RuleProtectMatch, evaluations[1].Rule)
+			assert.Equal(t, RuleAge, evaluations[2].Rule)
+		})
+	}
+}
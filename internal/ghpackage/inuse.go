@@ -0,0 +1,73 @@
+package ghpackage
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-github/v53/github"
+)
+
+// protectInUse protects all container versions which are referenced by InUse, either by tag or by digest.
+// If an in-use version is an image index all manifests reachable from it are protected as well.
+func (a *RetentionManager) protectInUse(ctx context.Context, packageName string, versions []*github.PackageVersion, packages map[string]*github.PackageVersion, protected map[int64]Rule) error {
+	if len(a.InUse) == 0 || a.PackageType != "container" {
+		return nil
+	}
+
+	tags := make(map[string]*github.PackageVersion)
+	for _, version := range versions {
+		for _, tag := range tagsOf(version) {
+			tags[tag] = version
+		}
+	}
+
+	repository := a.repository(packageName)
+	var inUse []*github.PackageVersion
+	for _, ref := range a.InUse {
+		if ref.Context().Name() != repository {
+			continue
+		}
+
+		var version *github.PackageVersion
+		switch ref := ref.(type) {
+		case name.Digest:
+			version = packages[ref.DigestStr()]
+		case name.Tag:
+			version = tags[ref.TagStr()]
+		}
+
+		if version == nil {
+			a.Logger.V(1).Info("in-use image reference does not match any package version", "package", packageName, "reference", ref.String())
+			continue
+		}
+
+		inUse = append(inUse, version)
+	}
+
+	for _, version := range inUse {
+		a.protect(packageName, version, RuleInUse, protected)
+
+		digests, err := a.garbageCollectManifests(ctx, packageName, version)
+		if err != nil {
+			return err
+		}
+
+		for _, digest := range digests {
+			if child, ok := packages[digest]; ok {
+				a.protect(packageName, child, RuleInUse, protected)
+			}
+		}
+	}
+
+	return nil
+}
+
+// protect protects a version unless it is already protected by another rule.
+func (a *RetentionManager) protect(packageName string, version *github.PackageVersion, rule Rule, protected map[int64]Rule) {
+	if _, ok := protected[*version.ID]; ok {
+		return
+	}
+
+	protected[*version.ID] = rule
+	a.Logger.Info("package version protected", "package", packageName, "version", *version.Name, "id", *version.ID, "tags", tagsOf(version), "reason", rule)
+}
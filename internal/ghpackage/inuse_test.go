@@ -0,0 +1,85 @@
+package ghpackage
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunInUse(t *testing.T) {
+	var (
+		childDigest    = "sha256:" + strings.Repeat("2", 64)
+		unusedDigest   = "sha256:" + strings.Repeat("3", 64)
+		deployedDigest = "sha256:" + strings.Repeat("4", 64)
+		index          = indexManifest(types.OCIImageIndex, descriptor(types.OCIManifestSchema1, childDigest))
+		indexDigest    = digestOf(index)
+	)
+
+	version := func(id int64, name string, tags ...string) *github.PackageVersion {
+		v := newVersion(id, name, tags...)
+		v.UpdatedAt = &github.Timestamp{Time: time.Now().Add(-time.Hour)}
+		return v
+	}
+
+	var inUse []name.Reference
+	for _, ref := range []string{
+		"ghcr.io/myorg/mypackage:v1.0.0",
+		"ghcr.io/myorg/mypackage@" + deployedDigest,
+		"ghcr.io/myorg/otherpackage:v2.0.0",
+		"ghcr.io/otherorg/mypackage:v2.0.0",
+	} {
+		r, err := name.ParseReference(ref)
+		assert.NoError(t, err)
+		inUse = append(inUse, r)
+	}
+
+	a := &RetentionManager{
+		PackageNames:     []string{"mypackage"},
+		PackageType:      "container",
+		OrganizationName: "myorg",
+		InUse:            inUse,
+		Logger:           logr.Discard(),
+		ContainerRegistryTransport: registryTransport{
+			"HEAD /v2/myorg/mypackage/manifests/v1.0.0":            headResponse(types.OCIImageIndex, indexDigest),
+			"GET /v2/myorg/mypackage/manifests/v1.0.0":             func() *http.Response { return manifestResponse(types.OCIImageIndex, index) },
+			"HEAD /v2/myorg/mypackage/manifests/" + deployedDigest: headResponse(types.OCIManifestSchema1, deployedDigest),
+		},
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				[]*github.PackageVersion{
+					version(1, indexDigest, "v1.0.0"),
+					version(2, childDigest),
+					version(3, unusedDigest, "v2.0.0"),
+					version(4, deployedDigest),
+				},
+			),
+			mock.WithRequestMatch(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				nil,
+			),
+		)),
+	}
+
+	deleted, err := a.Run(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []*PackageVersion{
+		{PackageName: "mypackage", Version: unusedDigest, ID: 3},
+	}, deleted)
+
+	rules := make(map[int64]Rule)
+	for _, evaluation := range a.Evaluations() {
+		rules[evaluation.ID] = evaluation.Rule
+	}
+
+	assert.Equal(t, map[int64]Rule{1: RuleInUse, 2: RuleInUse, 3: RuleAll, 4: RuleInUse}, rules)
+}
@@ -0,0 +1,170 @@
+package ghpackage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-github/v53/github"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "gh_package_retention"
+
+// Metrics are the prometheus metrics of retention runs.
+// A nil *Metrics disables the instrumentation.
+type Metrics struct {
+	Scanned                *prometheus.CounterVec
+	Elected                *prometheus.CounterVec
+	Deleted                *prometheus.CounterVec
+	Failed                 *prometheus.CounterVec
+	Protected              *prometheus.CounterVec
+	ReclaimedManifestBytes *prometheus.GaugeVec
+	RequestDuration        *prometheus.HistogramVec
+}
+
+// NewMetrics creates the retention metrics and registers them.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	labels := []string{"package", "package_type"}
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+
+	m := &Metrics{
+		Scanned:   counter("versions_scanned_total", "Number of evaluated package versions."),
+		Elected:   counter("versions_elected_total", "Number of package versions elected for deletion."),
+		Deleted:   counter("versions_deleted_total", "Number of deleted package versions."),
+		Failed:    counter("versions_failed_total", "Number of package versions or packages which failed."),
+		Protected: counter("versions_protected_total", "Number of package versions kept by protect-match, keep-last, semver or in-use."),
+		ReclaimedManifestBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "reclaimed_manifest_bytes",
+			Help:      "Size of the container manifests and image configs deleted by the last run. Layers are not included as they may be shared with other images.",
+		}, labels),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_request_duration_seconds",
+			Help:      "Latency of Github API and container registry requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"host", "method", "code"}),
+	}
+
+	registerer.MustRegister(m.Scanned, m.Elected, m.Deleted, m.Failed, m.Protected, m.ReclaimedManifestBytes, m.RequestDuration)
+	return m
+}
+
+// ObserveRequest records the latency of a http request. The code is "error" if no response was received.
+func (m *Metrics) ObserveRequest(req *http.Request, res *http.Response, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	code := "error"
+	if res != nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+
+	m.RequestDuration.WithLabelValues(req.URL.Host, req.Method, code).Observe(duration.Seconds())
+}
+
+// observe updates the metrics from the evaluations of a run.
+func (m *Metrics) observe(evaluations []*Evaluation) {
+	if m == nil {
+		return
+	}
+
+	type key struct {
+		packageName string
+		packageType string
+	}
+
+	reclaimed := make(map[key]int64)
+	for _, evaluation := range evaluations {
+		k := key{evaluation.PackageName, evaluation.PackageType}
+		reclaimed[k] += evaluation.reclaimed
+
+		if evaluation.ID == 0 {
+			m.Failed.WithLabelValues(k.packageName, k.packageType).Inc()
+			continue
+		}
+
+		m.Scanned.WithLabelValues(k.packageName, k.packageType).Inc()
+
+		switch evaluation.Decision {
+		case DecisionWouldDelete:
+			m.Elected.WithLabelValues(k.packageName, k.packageType).Inc()
+		case DecisionDeleted:
+			m.Elected.WithLabelValues(k.packageName, k.packageType).Inc()
+			m.Deleted.WithLabelValues(k.packageName, k.packageType).Inc()
+		case DecisionError:
+			m.Elected.WithLabelValues(k.packageName, k.packageType).Inc()
+			m.Failed.WithLabelValues(k.packageName, k.packageType).Inc()
+		case DecisionKept:
+			switch evaluation.Rule {
+			case RuleProtectMatch, RuleKeepLast, RuleSemver, RuleInUse:
+				m.Protected.WithLabelValues(k.packageName, k.packageType).Inc()
+			}
+		}
+	}
+
+	for k, size := range reclaimed {
+		m.ReclaimedManifestBytes.WithLabelValues(k.packageName, k.packageType).Set(float64(size))
+	}
+}
+
+// deleteVersion deletes an elected package version and records the outcome.
+func (a *RetentionManager) deleteVersion(ctx context.Context, packageVersion *PackageVersion) error {
+	size := a.versionSize(ctx, packageVersion)
+
+	_, err := a.deletePackageVersion(ctx, packageVersion.PackageName, packageVersion.ID)
+	a.recordDeletion(packageVersion, size, err)
+	return err
+}
+
+// versionSize returns the size of a container manifest including its image config.
+// Layers are not counted as they may be shared with images which are kept, the actual storage
+// reclaimed by the registry can therefore be larger.
+// The size is only looked up if metrics are enabled, it is 0 for other package types or if the lookup fails.
+func (a *RetentionManager) versionSize(ctx context.Context, packageVersion *PackageVersion) int64 {
+	if a.Metrics == nil || a.PackageType != "container" {
+		return 0
+	}
+
+	ref, err := name.NewDigest(fmt.Sprintf("%s@%s", a.repository(packageVersion.PackageName), packageVersion.Version))
+	if err != nil {
+		return 0
+	}
+
+	var descriptor *remote.Descriptor
+	err = a.retry(ctx, "get manifest", func() (*github.Response, error) {
+		descriptor, err = remote.Get(ref, a.registryOptions(ctx)...)
+		return nil, err
+	})
+
+	if err != nil {
+		a.Logger.V(1).Info("failed to look up the size of the package version", "package", packageVersion.PackageName, "version", packageVersion.Version, "id", packageVersion.ID, "err", err)
+		return 0
+	}
+
+	size := int64(len(descriptor.Manifest))
+	if descriptor.MediaType.IsIndex() {
+		return size
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(descriptor.Manifest))
+	if err != nil {
+		return size
+	}
+
+	return size + manifest.Config.Size
+}
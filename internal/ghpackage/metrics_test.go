@@ -0,0 +1,116 @@
+package ghpackage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMetrics(t *testing.T) {
+	manifest, err := json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        descriptor(types.OCIConfigJSON, "sha256:"+strings.Repeat("c", 64)),
+		Layers: []v1.Descriptor{
+			descriptor(types.OCILayer, "sha256:"+strings.Repeat("d", 64)),
+			descriptor(types.OCILayer, "sha256:"+strings.Repeat("e", 64)),
+		},
+	})
+	assert.NoError(t, err)
+
+	var (
+		now           = time.Now()
+		deletedDigest = digestOf(manifest)
+		failedDigest  = "sha256:" + strings.Repeat("f", 64)
+	)
+
+	version := func(id int64, name string, age time.Duration, tags ...string) *github.PackageVersion {
+		v := newVersion(id, name, tags...)
+		v.CreatedAt = &github.Timestamp{Time: now.Add(-age)}
+		v.UpdatedAt = &github.Timestamp{Time: now.Add(-age)}
+		return v
+	}
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	a := &RetentionManager{
+		PackageNames:     []string{"mypackage"},
+		PackageType:      "container",
+		OrganizationName: "myorg",
+		KeepLast:         1,
+		ContinueOnError:  true,
+		Metrics:          metrics,
+		Logger:           logr.Discard(),
+		ContainerRegistryTransport: registryTransport{
+			"GET /v2/myorg/mypackage/manifests/" + deletedDigest: func() *http.Response {
+				return manifestResponse(types.OCIManifestSchema1, manifest)
+			},
+		},
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				[]*github.PackageVersion{
+					version(1, "sha256:"+strings.Repeat("1", 64), time.Hour, "v2.0.0"),
+					version(2, deletedDigest, 2*time.Hour, "v1.0.0"),
+					version(3, failedDigest, 3*time.Hour, "v0.1.0"),
+				},
+			),
+			mock.WithRequestMatchHandler(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if strings.HasSuffix(r.URL.Path, "/"+strconv.Itoa(3)) {
+						mock.WriteError(w, http.StatusUnprocessableEntity, "failed")
+						return
+					}
+
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			),
+		)),
+	}
+
+	_, err = a.Run(context.TODO())
+	assert.Error(t, err)
+
+	for counter, expected := range map[*prometheus.CounterVec]float64{
+		metrics.Scanned:   3,
+		metrics.Elected:   2,
+		metrics.Deleted:   1,
+		metrics.Failed:    1,
+		metrics.Protected: 1,
+	} {
+		assert.Equal(t, expected, testutil.ToFloat64(counter.WithLabelValues("mypackage", "container")))
+	}
+
+	// The size of the deleted manifest and its config, layers are not counted
+	assert.Equal(t, float64(len(manifest)+1055), testutil.ToFloat64(metrics.ReclaimedManifestBytes.WithLabelValues("mypackage", "container")))
+}
+
+func TestObserveRequest(t *testing.T) {
+	var m *Metrics
+	req, _ := http.NewRequest(http.MethodGet, "https://api.github.com/user", nil)
+	m.ObserveRequest(req, nil, time.Second)
+
+	m = NewMetrics(prometheus.NewRegistry())
+	m.ObserveRequest(req, &http.Response{StatusCode: http.StatusOK}, time.Second)
+	m.ObserveRequest(req, nil, time.Second)
+
+	// One series per status code
+	assert.Equal(t, 2, testutil.CollectAndCount(m.RequestDuration))
+
+	problems, err := testutil.CollectAndLint(m.RequestDuration)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,143 @@
+package ghpackage
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v53/github"
+)
+
+const (
+	OwnerTypeOrganization = "organization"
+	OwnerTypeUser         = "user"
+)
+
+// ownedByOrganization returns true if the packages are owned by an organization.
+// Otherwise they are owned by UserName or, if UserName is empty, by the authenticated user.
+func (a *RetentionManager) ownedByOrganization() bool {
+	return a.OrganizationName != ""
+}
+
+// resolveOwner looks up the name of the package owner which is used for the container registry.
+func (a *RetentionManager) resolveOwner(ctx context.Context) error {
+	switch {
+	case a.ownedByOrganization():
+		a.owner = a.OrganizationName
+	case a.UserName != "":
+		a.owner = a.UserName
+	case a.PackageType == "container":
+		var user *github.User
+		err := a.retry(ctx, "get authenticated user", func() (*github.Response, error) {
+			var (
+				resp *github.Response
+				err  error
+			)
+
+			user, resp, err = a.GithubClient.Users.Get(ctx, "")
+			return resp, err
+		})
+
+		if err != nil {
+			return err
+		}
+
+		a.owner = user.GetLogin()
+	}
+
+	a.owner = strings.ToLower(a.owner)
+	return nil
+}
+
+func (a *RetentionManager) listPackageVersions(ctx context.Context, packageName string, opts *github.PackageListOptions) ([]*github.PackageVersion, *github.Response, error) {
+	var (
+		versions []*github.PackageVersion
+		resp     *github.Response
+	)
+
+	err := a.retry(ctx, "list package versions", func() (*github.Response, error) {
+		var err error
+		if a.ownedByOrganization() {
+			versions, resp, err = a.GithubClient.Organizations.PackageGetAllVersions(ctx, a.OrganizationName, a.PackageType, url.PathEscape(packageName), opts)
+		} else {
+			versions, resp, err = a.GithubClient.Users.PackageGetAllVersions(ctx, a.UserName, a.PackageType, url.PathEscape(packageName), opts)
+		}
+
+		return resp, err
+	})
+
+	return versions, resp, err
+}
+
+func (a *RetentionManager) getPackageVersion(ctx context.Context, packageName string, id int64) (*github.PackageVersion, error) {
+	var version *github.PackageVersion
+	err := a.retry(ctx, "get package version", func() (*github.Response, error) {
+		var (
+			resp *github.Response
+			err  error
+		)
+
+		if a.ownedByOrganization() {
+			version, resp, err = a.GithubClient.Organizations.PackageGetVersion(ctx, a.OrganizationName, a.PackageType, url.PathEscape(packageName), id)
+		} else {
+			version, resp, err = a.GithubClient.Users.PackageGetVersion(ctx, a.UserName, a.PackageType, url.PathEscape(packageName), id)
+		}
+
+		return resp, err
+	})
+
+	return version, err
+}
+
+// deletePackageVersion deletes a package version. A retried deletion which is not found anymore
+// succeeded, the failed attempt has been applied before its response got lost.
+func (a *RetentionManager) deletePackageVersion(ctx context.Context, packageName string, id int64) (*github.Response, error) {
+	var (
+		resp    *github.Response
+		attempt int
+	)
+
+	err := a.retry(ctx, "delete package version", func() (*github.Response, error) {
+		var err error
+		if a.ownedByOrganization() {
+			resp, err = a.GithubClient.Organizations.PackageDeleteVersion(ctx, a.OrganizationName, a.PackageType, url.PathEscape(packageName), id)
+		} else {
+			resp, err = a.GithubClient.Users.PackageDeleteVersion(ctx, a.UserName, a.PackageType, url.PathEscape(packageName), id)
+		}
+
+		if attempt > 0 && isNotFound(err) {
+			a.Logger.V(1).Info("package version has been deleted by a previous attempt", "package", packageName, "id", id)
+			return resp, nil
+		}
+
+		attempt++
+		return resp, err
+	})
+
+	return resp, err
+}
+
+func (a *RetentionManager) restorePackageVersion(ctx context.Context, packageName string, id int64) (*github.Response, error) {
+	var resp *github.Response
+	err := a.retry(ctx, "restore package version", func() (*github.Response, error) {
+		var err error
+		if a.ownedByOrganization() {
+			resp, err = a.GithubClient.Organizations.PackageRestoreVersion(ctx, a.OrganizationName, a.PackageType, url.PathEscape(packageName), id)
+		} else {
+			resp, err = a.GithubClient.Users.PackageRestoreVersion(ctx, a.UserName, a.PackageType, url.PathEscape(packageName), id)
+		}
+
+		return resp, err
+	})
+
+	return resp, err
+}
+
+// ownerType returns either "organization" or "user".
+func (a *RetentionManager) ownerType() string {
+	if a.ownedByOrganization() {
+		return OwnerTypeOrganization
+	}
+
+	return OwnerTypeUser
+}
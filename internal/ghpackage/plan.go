@@ -0,0 +1,220 @@
+package ghpackage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v53/github"
+)
+
+// Plan is a reviewable list of package versions which are elected for deletion.
+type Plan struct {
+	CreatedAt time.Time         `json:"createdAt"`
+	Versions  []*PlannedVersion `json:"versions"`
+}
+
+// PlannedVersion is a package version which is elected for deletion together with the rule which elected it.
+type PlannedVersion struct {
+	Owner       string    `json:"owner"`
+	OwnerType   string    `json:"ownerType"`
+	PackageType string    `json:"packageType"`
+	PackageName string    `json:"package"`
+	Version     string    `json:"version"`
+	Digest      string    `json:"digest,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	ID          int64     `json:"id"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Rule        Rule      `json:"rule"`
+}
+
+// NewPlan creates a plan from all package versions which would be deleted by a dry-run.
+func NewPlan(evaluations []*Evaluation) *Plan {
+	plan := &Plan{
+		CreatedAt: time.Now().UTC(),
+		Versions:  []*PlannedVersion{},
+	}
+
+	for _, evaluation := range evaluations {
+		if evaluation.Decision != DecisionWouldDelete {
+			continue
+		}
+
+		planned := &PlannedVersion{
+			Owner:       evaluation.Owner,
+			OwnerType:   evaluation.OwnerType,
+			PackageType: evaluation.PackageType,
+			PackageName: evaluation.PackageName,
+			Version:     evaluation.Version,
+			Tags:        evaluation.Tags,
+			ID:          evaluation.ID,
+			UpdatedAt:   evaluation.UpdatedAt,
+			Rule:        evaluation.Rule,
+		}
+
+		// The version name of a container is its manifest digest
+		if evaluation.PackageType == "container" {
+			planned.Digest = evaluation.Version
+		}
+
+		plan.Versions = append(plan.Versions, planned)
+	}
+
+	return plan
+}
+
+// Write writes the plan as json.
+func (p *Plan) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// ReadPlan parses a json plan.
+func ReadPlan(r io.Reader) (*Plan, error) {
+	plan := &Plan{}
+	if err := json.NewDecoder(r).Decode(plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+// Verify returns the planned package versions which can still be deleted, it is used with RunAll to apply a plan.
+// Each version is looked up again, versions which no longer exist or which changed since the plan
+// has been created are refused and kept. Packages which exceed their deletion budget are kept as well.
+func (a *RetentionManager) Verify(ctx context.Context, planned []*PlannedVersion) (verified []*PackageVersion, err error) {
+	a.evaluations = newEvaluationLog()
+	a.failures = &failureLog{}
+	a.pause = &rateLimitPause{}
+	defer func() {
+		if err != nil {
+			a.Metrics.observe(a.Evaluations())
+		}
+	}()
+
+	if err := a.resolveOwner(ctx); err != nil {
+		return nil, err
+	}
+
+	a.packageOrder = nil
+	packages := make(map[string][]*PackageVersion)
+
+	for _, p := range planned {
+		version, err := a.getPackageVersion(ctx, p.PackageName, p.ID)
+		if isNotFound(err) {
+			a.Logger.Info("refuse to delete planned package version as it does not exist anymore", "package", p.PackageName, "version", p.Version, "id", p.ID)
+			continue
+		}
+
+		if err != nil {
+			a.recordFailure(p.PackageName, err)
+			if err := a.failed(fmt.Errorf("package %s version %s (%d): %w", p.PackageName, p.Version, p.ID, err)); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if reason := changedSincePlan(p, version); reason != "" {
+			a.Logger.Info("refuse to delete planned package version as it changed since planning", "package", p.PackageName, "version", p.Version, "id", p.ID, "reason", reason)
+			a.keep(p.PackageName, version, RulePlanChanged)
+			continue
+		}
+
+		if _, ok := packages[p.PackageName]; !ok {
+			a.packageOrder = append(a.packageOrder, p.PackageName)
+		}
+
+		packages[p.PackageName] = append(packages[p.PackageName], &PackageVersion{
+			PackageName: p.PackageName,
+			Version:     p.Version,
+			ID:          p.ID,
+		})
+
+		a.record(p.PackageName, version, DecisionWouldDelete, p.Rule)
+	}
+
+	for _, packageName := range a.packageOrder {
+		if err := a.checkPlanBudget(ctx, packageName, packages[packageName]); err != nil {
+			a.keepElected(packages[packageName], RuleDeletionBudget)
+			a.recordFailure(packageName, err)
+			if err := a.failed(fmt.Errorf("package %s: %w", packageName, err)); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		verified = append(verified, packages[packageName]...)
+	}
+
+	return verified, nil
+}
+
+// checkPlanBudget checks the per package budgets of the verified versions of a plan.
+// The versions of the package are only listed if max-delete-percent is set.
+func (a *RetentionManager) checkPlanBudget(ctx context.Context, packageName string, packageVersions []*PackageVersion) error {
+	var versions int
+	if a.MaxDeletePercent > 0 {
+		all, err := a.getAllVersionsForPackage(ctx, packageName)
+		if err != nil {
+			return err
+		}
+
+		versions = len(all)
+	}
+
+	return a.checkBudget(packageName, len(packageVersions), versions)
+}
+
+// changedSincePlan returns why the current package version differs from the planned one.
+// It returns an empty string if the version is unchanged.
+func changedSincePlan(planned *PlannedVersion, version *github.PackageVersion) string {
+	if version.GetName() != planned.Version {
+		return fmt.Sprintf("version changed from %s to %s", planned.Version, version.GetName())
+	}
+
+	tags := tagsOf(version)
+	if !sameTags(tags, planned.Tags) {
+		return fmt.Sprintf("tags changed from %v to %v", planned.Tags, tags)
+	}
+
+	var updatedAt time.Time
+	if version.UpdatedAt != nil {
+		updatedAt = version.UpdatedAt.Time
+	}
+
+	if !updatedAt.Equal(planned.UpdatedAt) {
+		return fmt.Sprintf("updated at %s instead of %s", updatedAt.Format(time.RFC3339), planned.UpdatedAt.Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, tag := range a {
+		if !contains(b, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNotFound(err error) bool {
+	var errResponse *github.ErrorResponse
+	if !errors.As(err, &errResponse) || errResponse.Response == nil {
+		return false
+	}
+
+	return errResponse.Response.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,284 @@
+package ghpackage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPlan(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	evaluations := []*Evaluation{
+		{
+			Owner:       "myorg",
+			OwnerType:   OwnerTypeOrganization,
+			PackageType: "container",
+			PackageName: "mypackage",
+			Version:     "sha256:a60d0af675b0bad03ebdb529ed1b6009604063136f30516568028008c221e62d",
+			Tags:        []string{"v1.0.0"},
+			ID:          1,
+			UpdatedAt:   updatedAt,
+			Decision:    DecisionWouldDelete,
+			Rule:        RuleAge,
+		},
+		{
+			Owner:       "myorg",
+			OwnerType:   OwnerTypeOrganization,
+			PackageType: "container",
+			PackageName: "mypackage",
+			Version:     "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f",
+			ID:          2,
+			Decision:    DecisionKept,
+			Rule:        RuleKeepLast,
+		},
+		{
+			Owner:       "myorg",
+			OwnerType:   OwnerTypeOrganization,
+			PackageType: "maven",
+			PackageName: "org.example.app",
+			Version:     "1.0.0-SNAPSHOT",
+			ID:          3,
+			UpdatedAt:   updatedAt,
+			Decision:    DecisionWouldDelete,
+			Rule:        RuleVersionMatch,
+		},
+	}
+
+	plan := NewPlan(evaluations)
+	assert.Equal(t, []*PlannedVersion{
+		{
+			Owner:       "myorg",
+			OwnerType:   OwnerTypeOrganization,
+			PackageType: "container",
+			PackageName: "mypackage",
+			Version:     "sha256:a60d0af675b0bad03ebdb529ed1b6009604063136f30516568028008c221e62d",
+			Digest:      "sha256:a60d0af675b0bad03ebdb529ed1b6009604063136f30516568028008c221e62d",
+			Tags:        []string{"v1.0.0"},
+			ID:          1,
+			UpdatedAt:   updatedAt,
+			Rule:        RuleAge,
+		},
+		{
+			Owner:       "myorg",
+			OwnerType:   OwnerTypeOrganization,
+			PackageType: "maven",
+			PackageName: "org.example.app",
+			Version:     "1.0.0-SNAPSHOT",
+			ID:          3,
+			UpdatedAt:   updatedAt,
+			Rule:        RuleVersionMatch,
+		},
+	}, plan.Versions)
+
+	var b bytes.Buffer
+	assert.NoError(t, plan.Write(&b))
+
+	read, err := ReadPlan(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, plan.Versions, read.Versions)
+	assert.True(t, plan.CreatedAt.Equal(read.CreatedAt))
+
+	_, err = ReadPlan(strings.NewReader("versions: []"))
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	planned := func(id int64, version string, tags ...string) *PlannedVersion {
+		return &PlannedVersion{
+			PackageName: "mypackage",
+			Version:     version,
+			Tags:        tags,
+			ID:          id,
+			UpdatedAt:   updatedAt,
+			Rule:        RuleAge,
+		}
+	}
+
+	current := func(id int64, version string, updatedAt time.Time, tags ...string) *github.PackageVersion {
+		v := newVersion(id, version, tags...)
+		v.UpdatedAt = &github.Timestamp{Time: updatedAt}
+		return v
+	}
+
+	var tests = []struct {
+		name                string
+		dryRun              bool
+		force               bool
+		maxDeletions        int
+		maxPackageDeletions int
+		planned             []*PlannedVersion
+		current             map[int64]*github.PackageVersion
+		expected            []*PackageVersion
+		decisions           map[int64]Decision
+		expectedErr         error
+	}{
+		{
+			name:    "Unchanged package versions are deleted",
+			planned: []*PlannedVersion{planned(1, "sha256:aaaa", "v1.0.0"), planned(2, "sha256:bbbb")},
+			current: map[int64]*github.PackageVersion{
+				1: current(1, "sha256:aaaa", updatedAt, "v1.0.0"),
+				2: current(2, "sha256:bbbb", updatedAt),
+			},
+			expected: []*PackageVersion{
+				{PackageName: "mypackage", Version: "sha256:aaaa", ID: 1},
+				{PackageName: "mypackage", Version: "sha256:bbbb", ID: 2},
+			},
+			decisions: map[int64]Decision{1: DecisionDeleted, 2: DecisionDeleted},
+		},
+		{
+			name:    "Package versions are not deleted in dry-run mode",
+			dryRun:  true,
+			planned: []*PlannedVersion{planned(1, "sha256:aaaa")},
+			current: map[int64]*github.PackageVersion{
+				1: current(1, "sha256:aaaa", updatedAt),
+			},
+			decisions: map[int64]Decision{1: DecisionWouldDelete},
+		},
+		{
+			name:    "Package versions which changed since planning are refused",
+			planned: []*PlannedVersion{planned(1, "sha256:aaaa"), planned(2, "sha256:bbbb"), planned(3, "sha256:cccc"), planned(4, "sha256:dddd")},
+			current: map[int64]*github.PackageVersion{
+				1: current(1, "sha256:ffff", updatedAt),
+				2: current(2, "sha256:bbbb", updatedAt.Add(time.Hour)),
+				3: current(3, "sha256:cccc", updatedAt, "latest"),
+				4: current(4, "sha256:dddd", updatedAt),
+			},
+			expected: []*PackageVersion{
+				{PackageName: "mypackage", Version: "sha256:dddd", ID: 4},
+			},
+			decisions: map[int64]Decision{1: DecisionKept, 2: DecisionKept, 3: DecisionKept, 4: DecisionDeleted},
+		},
+		{
+			name:    "Package versions which do not exist anymore are refused",
+			planned: []*PlannedVersion{planned(1, "sha256:aaaa"), planned(2, "sha256:bbbb")},
+			current: map[int64]*github.PackageVersion{
+				2: current(2, "sha256:bbbb", updatedAt),
+			},
+			expected: []*PackageVersion{
+				{PackageName: "mypackage", Version: "sha256:bbbb", ID: 2},
+			},
+			decisions: map[int64]Decision{2: DecisionDeleted},
+		},
+		{
+			name:         "Nothing is deleted if the verified versions exceed max-deletions",
+			maxDeletions: 1,
+			planned:      []*PlannedVersion{planned(1, "sha256:aaaa"), planned(2, "sha256:bbbb"), planned(3, "sha256:cccc")},
+			current: map[int64]*github.PackageVersion{
+				1: current(1, "sha256:aaaa", updatedAt),
+				2: current(2, "sha256:bbbb", updatedAt),
+				3: current(3, "sha256:cccc", updatedAt.Add(time.Hour)),
+			},
+			decisions:   map[int64]Decision{1: DecisionKept, 2: DecisionKept, 3: DecisionKept},
+			expectedErr: ErrDeletionBudgetExceeded,
+		},
+		{
+			name:         "Refused versions do not count towards max-deletions",
+			maxDeletions: 1,
+			planned:      []*PlannedVersion{planned(1, "sha256:aaaa"), planned(2, "sha256:bbbb")},
+			current: map[int64]*github.PackageVersion{
+				2: current(2, "sha256:bbbb", updatedAt),
+			},
+			expected: []*PackageVersion{
+				{PackageName: "mypackage", Version: "sha256:bbbb", ID: 2},
+			},
+			decisions: map[int64]Decision{2: DecisionDeleted},
+		},
+		{
+			name:                "Nothing is deleted if the verified versions exceed max-package-deletions",
+			maxPackageDeletions: 1,
+			planned:             []*PlannedVersion{planned(1, "sha256:aaaa"), planned(2, "sha256:bbbb")},
+			current: map[int64]*github.PackageVersion{
+				1: current(1, "sha256:aaaa", updatedAt),
+				2: current(2, "sha256:bbbb", updatedAt),
+			},
+			decisions:   map[int64]Decision{0: DecisionError, 1: DecisionKept, 2: DecisionKept},
+			expectedErr: ErrDeletionBudgetExceeded,
+		},
+		{
+			name:         "Exceeded deletion budgets are ignored if force is set",
+			force:        true,
+			maxDeletions: 1,
+			planned:      []*PlannedVersion{planned(1, "sha256:aaaa"), planned(2, "sha256:bbbb")},
+			current: map[int64]*github.PackageVersion{
+				1: current(1, "sha256:aaaa", updatedAt),
+				2: current(2, "sha256:bbbb", updatedAt),
+			},
+			expected: []*PackageVersion{
+				{PackageName: "mypackage", Version: "sha256:aaaa", ID: 1},
+				{PackageName: "mypackage", Version: "sha256:bbbb", ID: 2},
+			},
+			decisions: map[int64]Decision{1: DecisionDeleted, 2: DecisionDeleted},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var deleteCalls []int64
+
+			a := &RetentionManager{
+				OrganizationName:    "myorg",
+				PackageType:         "container",
+				DryRun:              test.dryRun,
+				Force:               test.force,
+				MaxDeletions:        test.maxDeletions,
+				MaxPackageDeletions: test.maxPackageDeletions,
+				Logger:              logr.Discard(),
+				GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+					mock.WithRequestMatchHandler(
+						mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							id, _ := strconv.ParseInt(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], 10, 64)
+							version, ok := test.current[id]
+							if !ok {
+								mock.WriteError(w, http.StatusNotFound, "not found")
+								return
+							}
+
+							_, _ = w.Write(mock.MustMarshal(version))
+						}),
+					),
+					mock.WithRequestMatchHandler(
+						mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							id, _ := strconv.ParseInt(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], 10, 64)
+							deleteCalls = append(deleteCalls, id)
+							w.WriteHeader(http.StatusNoContent)
+						}),
+					),
+				)),
+			}
+
+			deleted, failures := RunAll(context.TODO(), a, []*RetentionManager{a}, func(a *RetentionManager) ([]*PackageVersion, error) {
+				return a.Verify(context.TODO(), test.planned)
+			})
+
+			err := errors.Join(failures...)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expected, deleted)
+			assert.Len(t, deleteCalls, len(test.expected))
+
+			decisions := make(map[int64]Decision)
+			for _, evaluation := range a.Evaluations() {
+				decisions[evaluation.ID] = evaluation.Decision
+			}
+
+			assert.Equal(t, test.decisions, decisions)
+		})
+	}
+}
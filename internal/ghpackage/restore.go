@@ -0,0 +1,29 @@
+package ghpackage
+
+import (
+	"context"
+)
+
+// Restore restores previously deleted package versions of the package owner.
+// Deleted package versions can be restored up to 30 days after their deletion.
+// In dry-run mode the package versions are only logged.
+func (a *RetentionManager) Restore(ctx context.Context, packageVersions []*PackageVersion) ([]*PackageVersion, error) {
+	var restored []*PackageVersion
+	for _, packageVersion := range packageVersions {
+		a.Logger.Info("restoring package version", "package", packageVersion.PackageName, "version", packageVersion.Version, "id", packageVersion.ID)
+
+		if a.DryRun {
+			continue
+		}
+
+		_, err := a.restorePackageVersion(ctx, packageVersion.PackageName, packageVersion.ID)
+		if err != nil {
+			return restored, err
+		}
+
+		a.Logger.Info("package version restored", "package", packageVersion.PackageName, "version", packageVersion.Version, "id", packageVersion.ID)
+		restored = append(restored, packageVersion)
+	}
+
+	return restored, nil
+}
@@ -0,0 +1,86 @@
+package ghpackage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestore(t *testing.T) {
+	packageVersions := []*PackageVersion{
+		{
+			PackageName: "mypackage",
+			Version:     "package-1",
+			ID:          1,
+		},
+	}
+
+	var tests = []struct {
+		name             string
+		RetentionManager *RetentionManager
+		expected         []*PackageVersion
+	}{
+		{
+			name: "Package versions owned by an organization are restored",
+			RetentionManager: &RetentionManager{
+				OrganizationName: "myorg",
+				GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+					mock.WithRequestMatch(
+						mock.PostOrgsPackagesVersionsRestoreByOrgByPackageTypeByPackageNameByPackageVersionId,
+						nil,
+					),
+				)),
+			},
+			expected: packageVersions,
+		},
+		{
+			name: "Package versions owned by a user are restored",
+			RetentionManager: &RetentionManager{
+				UserName: "myuser",
+				GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+					mock.WithRequestMatch(
+						mock.PostUsersPackagesVersionsRestoreByUsernameByPackageTypeByPackageNameByPackageVersionId,
+						nil,
+					),
+				)),
+			},
+			expected: packageVersions,
+		},
+		{
+			name: "Package versions owned by the authenticated user are restored",
+			RetentionManager: &RetentionManager{
+				GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+					mock.WithRequestMatch(
+						mock.PostUserPackagesVersionsRestoreByPackageTypeByPackageNameByPackageVersionId,
+						nil,
+					),
+				)),
+			},
+			expected: packageVersions,
+		},
+		{
+			name: "Package versions are not restored in dry-run",
+			RetentionManager: &RetentionManager{
+				OrganizationName: "myorg",
+				DryRun:           true,
+				GithubClient:     github.NewClient(mock.NewMockedHTTPClient()),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := test.RetentionManager
+			a.Logger = logr.Discard()
+			a.PackageType = "container"
+
+			restored, err := a.Restore(context.TODO(), packageVersions)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, restored)
+		})
+	}
+}
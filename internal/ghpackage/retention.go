@@ -2,33 +2,76 @@ package ghpackage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"regexp"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/go-github/v53/github"
+	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 )
 
+// UntaggedMode defines how untagged container versions are handled.
+type UntaggedMode string
+
+const (
+	// UntaggedKeep never removes untagged container versions directly.
+	UntaggedKeep UntaggedMode = "keep"
+	// UntaggedDelete removes untagged container versions like any other version.
+	// Untagged versions which are still reachable from a surviving tag (e.g. the platform manifests of a kept index) are never removed.
+	UntaggedDelete UntaggedMode = "delete"
+	// UntaggedDeleteUnreferenced removes untagged container versions which are not referenced by any surviving tag.
+	// It behaves like UntaggedDelete.
+	UntaggedDeleteUnreferenced UntaggedMode = "delete-unreferenced"
+)
+
 type RetentionManager struct {
 	OrganizationName           string
+	UserName                   string
 	PackageType                string
 	PackageNames               []string
 	Age                        time.Duration
 	Token                      string
+	TokenSource                oauth2.TokenSource
 	DryRun                     bool
 	ContainerRegistryTransport http.RoundTripper
+	RegistryHost               string
 	VersionMatch               *regexp.Regexp
+	ProtectMatch               *regexp.Regexp
+	InUse                      []name.Reference
 	GithubClient               *github.Client
 	Logger                     logr.Logger
 	MaxVersions                int
+	MaxRetries                 int
+	Concurrency                int
+	KeepLast                   int
+	Semver                     *SemverPolicy
+	Untagged                   UntaggedMode
+	CompanionArtifacts         bool
+	AllPackages                bool
+	PackageMatch               *regexp.Regexp
+	Repository                 string
+	ContinueOnError            bool
+	MaxDeletions               int
+	MaxPackageDeletions        int
+	MaxDeletePercent           float64
+	Force                      bool
+	Metrics                    *Metrics
+	owner                      string
+	packageOrder               []string
+	evaluations                *evaluationLog
+	failures                   *failureLog
+	pause                      *rateLimitPause
+	groups                     *electionGroups
 }
 
 type PackageVersion struct {
@@ -37,65 +80,434 @@ type PackageVersion struct {
 	ID          int64
 }
 
+// Run evaluates all packages and deletes the elected package versions.
+// Nothing is deleted if the deletion budget of the run is exceeded.
 func (a *RetentionManager) Run(ctx context.Context) ([]*PackageVersion, error) {
-	var removed []*PackageVersion
-	toDelete := make(chan *PackageVersion)
-	wg, ctx := errgroup.WithContext(ctx)
+	removed, failures := RunAll(ctx, a, []*RetentionManager{a}, func(a *RetentionManager) ([]*PackageVersion, error) {
+		return a.Evaluate(ctx)
+	})
 
-	wg.Go(func() error {
-		defer close(toDelete)
+	if len(failures) == 1 {
+		return removed, failures[0]
+	}
+
+	return removed, errors.Join(failures...)
+}
+
+// RunAll elects the package versions of all managers using elect before anything is deleted.
+// The deletion budget of base is checked against the elections of all managers together.
+// Without ContinueOnError of base nothing is deleted if an election fails and the deletion stops at the
+// first manager which fails. Managers whose elections are not deleted are aborted and their collected
+// failures are returned together with all other failures.
+func RunAll(ctx context.Context, base *RetentionManager, managers []*RetentionManager, elect func(*RetentionManager) ([]*PackageVersion, error)) ([]*PackageVersion, []error) {
+	var (
+		removed   []*PackageVersion
+		failures  []error
+		succeeded []*RetentionManager
+		elections [][]*PackageVersion
+		total     int
+	)
+
+	for _, a := range managers {
+		elected, err := elect(a)
+		if err != nil {
+			failures = append(failures, err)
+			if !base.ContinueOnError {
+				break
+			}
+
+			continue
+		}
+
+		succeeded = append(succeeded, a)
+		elections = append(elections, elected)
+		total += len(elected)
+	}
 
-		for _, packageName := range a.PackageNames {
-			if err := a.findPackages(ctx, packageName, toDelete); err != nil {
-				return err
+	stopped := len(failures) > 0 && !base.ContinueOnError
+	if !stopped {
+		if err := base.checkRunBudget(total); err != nil {
+			failures = append(failures, err)
+			stopped = true
+		}
+	}
+
+	for i, a := range succeeded {
+		if stopped {
+			a.abort(elections[i])
+			if err := a.failures.err(); err != nil {
+				failures = append(failures, err)
 			}
+
+			continue
 		}
 
-		return nil
+		r, err := a.deleteElected(ctx, elections[i])
+		removed = append(removed, r...)
+
+		if err != nil {
+			failures = append(failures, err)
+			stopped = !base.ContinueOnError
+		}
+	}
+
+	return removed, failures
+}
+
+// Evaluate evaluates all packages and returns the package versions elected for deletion without deleting anything.
+// It is used with RunAll which deletes the elected versions.
+func (a *RetentionManager) Evaluate(ctx context.Context) (elected []*PackageVersion, err error) {
+	a.evaluations = newEvaluationLog()
+	a.failures = &failureLog{}
+	a.pause = &rateLimitPause{}
+	a.groups = newElectionGroups()
+	defer func() {
+		if err != nil {
+			a.Metrics.observe(a.Evaluations())
+		}
+	}()
+
+	if err := a.resolveOwner(ctx); err != nil {
+		return nil, err
+	}
+
+	packageNames, discovered, err := a.packageNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	a.packageOrder = packageNames
+	finders, findCtx := errgroup.WithContext(ctx)
+	finders.SetLimit(a.workers())
+
+	// All packages are evaluated before anything is deleted, hence the run budget covers all elected versions
+	elections := make([][]*PackageVersion, len(packageNames))
+
+	for i, packageName := range packageNames {
+		if findCtx.Err() != nil {
+			break
+		}
+
+		i, packageName := i, packageName
+		finders.Go(func() error {
+			pending, err := a.findPackages(findCtx, packageName)
+			if err != nil {
+				if _, ok := discovered[packageName]; ok && isAccessError(err) {
+					a.Logger.Info("skip discovered package as it is not accessible", "package", packageName, "err", err)
+					return nil
+				}
+
+				a.recordFailure(packageName, err)
+				return a.failed(fmt.Errorf("package %s: %w", packageName, err))
+			}
+
+			elections[i] = pending
+			return nil
+		})
+	}
+
+	if err := finders.Wait(); err != nil {
+		return nil, err
+	}
+
+	for _, election := range elections {
+		elected = append(elected, election...)
+	}
+
+	return a.capElections(elected), nil
+}
+
+// capElections keeps the oldest MaxVersions elected package versions across all packages.
+// Versions which depend on each other (an index and its manifests, an image and its companions) are
+// capped as a whole group, so a surviving tag never points to deleted manifests.
+// It runs once all packages are evaluated so the result does not depend on the order in which concurrent finders finished.
+func (a *RetentionManager) capElections(elected []*PackageVersion) []*PackageVersion {
+	if a.MaxVersions <= 0 || len(elected) <= a.MaxVersions {
+		return elected
+	}
+
+	updated := a.updatedAt(elected)
+	groups := a.groups.split(elected)
+	oldest := func(group []*PackageVersion) (time.Time, int64) {
+		t, id := updated[group[0].ID], group[0].ID
+		for _, packageVersion := range group[1:] {
+			if u := updated[packageVersion.ID]; u.Before(t) || (u.Equal(t) && packageVersion.ID < id) {
+				t, id = u, packageVersion.ID
+			}
+		}
+
+		return t, id
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		ti, idi := oldest(groups[i])
+		tj, idj := oldest(groups[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+
+		return idi < idj
 	})
 
+	var capped, skipped []*PackageVersion
+	for _, group := range groups {
+		if len(capped)+len(group) > a.MaxVersions {
+			skipped = append(skipped, group...)
+			continue
+		}
+
+		capped = append(capped, group...)
+	}
+
+	if len(skipped) > 0 {
+		a.keepElected(skipped, RuleMaxVersions)
+		a.Logger.Info("max-versions reached, remaining elected package versions are kept until the next run", "max-versions", a.MaxVersions, "kept", len(skipped))
+	}
+
+	return capped
+}
+
+// electionGroups tracks elected package versions which must be deleted together.
+type electionGroups struct {
+	mu     sync.Mutex
+	parent map[int64]int64
+}
+
+func newElectionGroups() *electionGroups {
+	return &electionGroups{
+		parent: make(map[int64]int64),
+	}
+}
+
+// join puts both package versions into the same group.
+func (g *electionGroups) join(id, other int64) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if root, otherRoot := g.root(id), g.root(other); root != otherRoot {
+		g.parent[otherRoot] = root
+	}
+}
+
+func (g *electionGroups) root(id int64) int64 {
+	for {
+		parent, ok := g.parent[id]
+		if !ok {
+			return id
+		}
+
+		id = parent
+	}
+}
+
+// split partitions the package versions by their group, keeping the order of the first member of each group.
+func (g *electionGroups) split(packageVersions []*PackageVersion) [][]*PackageVersion {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var groups [][]*PackageVersion
+	index := make(map[int64]int)
+	for _, packageVersion := range packageVersions {
+		root := g.root(packageVersion.ID)
+		i, ok := index[root]
+		if !ok {
+			i = len(groups)
+			index[root] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], packageVersion)
+	}
+
+	return groups
+}
+
+// deleteElected deletes the elected package versions using Concurrency workers.
+// The deleted versions are returned ordered by package and ID regardless of the concurrency.
+func (a *RetentionManager) deleteElected(ctx context.Context, elected []*PackageVersion) ([]*PackageVersion, error) {
+	var removed []*PackageVersion
+	defer func() {
+		a.Metrics.observe(a.Evaluations())
+	}()
+
+	toDelete := make(chan *PackageVersion)
+	wg, ctx := errgroup.WithContext(ctx)
+
 	wg.Go(func() error {
-		r, err := a.deletePackages(ctx, toDelete)
-		removed = append(removed, r...)
-		return err
+		defer close(toDelete)
+		return send(ctx, toDelete, elected)
 	})
 
+	var mu sync.Mutex
+	for i := 0; i < a.workers(); i++ {
+		wg.Go(func() error {
+			r, err := a.deletePackages(ctx, toDelete)
+
+			mu.Lock()
+			defer mu.Unlock()
+			removed = append(removed, r...)
+
+			return err
+		})
+	}
+
 	err := wg.Wait()
-	return removed, err
+	sortPackageVersions(removed, a.packageOrder)
+
+	if err != nil {
+		return removed, err
+	}
+
+	return removed, a.failures.err()
+}
+
+// abort keeps the elected package versions as they are not going to be deleted.
+func (a *RetentionManager) abort(elected []*PackageVersion) {
+	a.keepElected(elected, RuleDeletionBudget)
+	a.Metrics.observe(a.Evaluations())
+}
+
+// failureLog collects the failures of a run if ContinueOnError is enabled.
+type failureLog struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// err returns all collected failures joined into a single error.
+func (f *failureLog) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return errors.Join(f.errs...)
+}
+
+// failed returns the error unless ContinueOnError is enabled.
+// Otherwise the error is logged and collected to be returned once the run has finished.
+func (a *RetentionManager) failed(err error) error {
+	if !a.ContinueOnError {
+		return err
+	}
+
+	a.Logger.Error(err, "continue after failure")
+
+	a.failures.mu.Lock()
+	defer a.failures.mu.Unlock()
+	a.failures.errs = append(a.failures.errs, err)
+
+	return nil
+}
+
+// send passes the package versions to the deletion workers.
+func send(ctx context.Context, toDelete chan<- *PackageVersion, packageVersions []*PackageVersion) error {
+	for _, packageVersion := range packageVersions {
+		select {
+		case toDelete <- packageVersion:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// workers returns the number of concurrent discovery and deletion workers.
+func (a *RetentionManager) workers() int {
+	if a.Concurrency < 1 {
+		return 1
+	}
+
+	return a.Concurrency
+}
+
+// sortPackageVersions sorts package versions by the order of their package in packageNames and by their ID.
+// This keeps the result deterministic regardless of the order in which concurrent workers processed them.
+func sortPackageVersions(packageVersions []*PackageVersion, packageNames []string) {
+	position := make(map[string]int, len(packageNames))
+	for i, packageName := range packageNames {
+		position[packageName] = i
+	}
+
+	sort.SliceStable(packageVersions, func(i, j int) bool {
+		if packageVersions[i].PackageName != packageVersions[j].PackageName {
+			return position[packageVersions[i].PackageName] < position[packageVersions[j].PackageName]
+		}
+
+		return packageVersions[i].ID < packageVersions[j].ID
+	})
 }
 
-func (a *RetentionManager) findPackages(ctx context.Context, packageName string, toDelete chan *PackageVersion) error {
+// findPackages evaluates all versions of the package and returns the versions elected for deletion.
+func (a *RetentionManager) findPackages(ctx context.Context, packageName string) ([]*PackageVersion, error) {
 	versions, err := a.getAllVersionsForPackage(ctx, packageName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	// The API lists the newest versions first, evaluate the oldest first so they are deleted first.
+	sort.SliceStable(versions, func(i, j int) bool {
+		return updatedAt(versions[i]).Before(updatedAt(versions[j]))
+	})
+
 	packages := make(map[string]*github.PackageVersion)
+	for _, version := range versions {
+		packages[*version.Name] = version
+	}
+
+	elected := make(map[int64]struct{})
+	var pending []*PackageVersion
 	var references []string
+	var untaggedVersions []*github.PackageVersion
+	var companions []*github.PackageVersion
+	// manifests maps the digests referenced by an elected index to the index versions
+	manifests := make(map[string][]int64)
+	protected := a.protectedVersions(packageName, versions)
+	if err := a.protectInUse(ctx, packageName, versions, packages, protected); err != nil {
+		return nil, err
+	}
+
+	untaggedMode := a.untaggedMode()
 
 	for _, version := range versions {
 		a.Logger.Info("checking package version", "package", packageName, "version", *version.Name, "id", *version.ID)
-		packages[*version.Name] = version
 
-		if a.VersionMatch != nil {
+		if reason, ok := protected[*version.ID]; ok {
+			a.Logger.V(1).Info("skip package version as it is protected", "package", packageName, "version", *version.Name, "id", *version.ID, "reason", reason)
+			a.keep(packageName, version, reason)
+			continue
+		}
+
+		if a.CompanionArtifacts && a.PackageType == "container" {
+			if subject, ok := companionSubject(version); ok {
+				if _, ok := packages[subject]; ok {
+					companions = append(companions, version)
+					continue
+				}
+			}
+		}
+
+		untagged := a.PackageType == "container" && len(tagsOf(version)) == 0
+		if untagged && untaggedMode == UntaggedKeep {
+			a.Logger.V(1).Info("skip package version as untagged versions are kept", "package", packageName, "version", *version.Name, "id", *version.ID)
+			a.keep(packageName, version, RuleUntagged)
+			continue
+		}
+
+		if a.VersionMatch != nil && !untagged {
 			switch a.PackageType {
 			case "container":
 				if !a.matchContainer(version) {
 					a.Logger.V(1).Info("skip package version as version does not match the required match regex", "package", packageName, "version", *version.Name, "id", *version.ID)
+					a.keep(packageName, version, RuleVersionMatch)
 					continue
 				}
-
-				if version.Metadata.Container != nil && len(version.Metadata.Container.Tags) > 0 {
-					tags, err := a.garbageCollectManifests(ctx, packageName, version)
-					if err != nil {
-						return err
-					}
-
-					references = append(references, tags...)
-				}
 			default:
 				if !a.VersionMatch.MatchString(*version.Name) {
 					a.Logger.V(1).Info("skip package version as version does not match the required match regex", "package", packageName, "version", *version.Name, "id", *version.ID)
+					a.keep(packageName, version, RuleVersionMatch)
 					continue
 				}
 			}
@@ -103,83 +515,411 @@ func (a *RetentionManager) findPackages(ctx context.Context, packageName string,
 
 		if version.UpdatedAt == nil {
 			a.Logger.V(1).Info("skip package version as no update timestamp exists", "package", packageName, "version", *version.Name, "id", *version.ID)
+			a.keep(packageName, version, RuleNoTimestamp)
 			continue
 		}
 
 		if a.Age != 0 {
 			if version.UpdatedAt.Time.Add(a.Age).After(time.Now()) {
 				a.Logger.V(1).Info("skip package version as age is too new", "package", packageName, "version", *version.Name, "id", *version.ID, "age", version.UpdatedAt)
+				a.keep(packageName, version, RuleAge)
 				continue
 			}
 		}
 
-		a.Logger.Info("package elected for deletion", "package", packageName, "version", *version.Name, "id", *version.ID)
+		if untagged {
+			untaggedVersions = append(untaggedVersions, version)
+			continue
+		}
 
-		select {
-		case toDelete <- &PackageVersion{
-			Version:     *version.Name,
-			PackageName: packageName,
-			ID:          *version.ID,
-		}:
-		case <-ctx.Done():
-			return ctx.Err()
+		// The manifests of an index are needed to cap it together with its manifests if max-versions is set
+		if a.PackageType == "container" && (a.VersionMatch != nil || a.MaxVersions > 0) {
+			tags, err := a.garbageCollectManifests(ctx, packageName, version)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, tag := range tags {
+				manifests[tag] = append(manifests[tag], *version.ID)
+			}
+
+			if a.VersionMatch != nil {
+				references = append(references, tags...)
+			}
 		}
 
+		a.elect(packageName, version, a.electionRule(), elected, &pending)
+	}
+
+	reachable := make(map[string]struct{})
+	attached := make(map[string]struct{})
+	// Untagged versions are never deleted while a surviving tag can still reach them
+	if len(references) > 0 || len(untaggedVersions) > 0 {
+		reachable, attached, err = a.survivingReferences(ctx, packageName, versions, elected)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	for _, reference := range references {
 		if pv, ok := packages[reference]; ok {
+			if _, ok := elected[*pv.ID]; ok {
+				continue
+			}
+
+			if reason, ok := protected[*pv.ID]; ok {
+				a.Logger.V(1).Info("skip referenced package version as it is protected", "package", packageName, "version", *pv.Name, "id", *pv.ID, "reason", reason)
+				a.keep(packageName, pv, reason)
+				continue
+			}
+
+			if _, ok := reachable[reference]; ok {
+				a.Logger.V(1).Info("skip referenced package version as it is still referenced by a surviving tag", "package", packageName, "version", *pv.Name, "id", *pv.ID)
+				a.keep(packageName, pv, RuleReferenced)
+				continue
+			}
+
+			if _, ok := attached[reference]; ok {
+				a.Logger.V(1).Info("skip referenced package version as it is attached to a surviving tag", "package", packageName, "version", *pv.Name, "id", *pv.ID)
+				a.keep(packageName, pv, RuleAttached)
+				continue
+			}
+
 			if a.Age != 0 {
 				if pv.UpdatedAt.Time.Add(a.Age).After(time.Now()) {
+					a.keep(packageName, pv, RuleAge)
 					continue
 				}
 			}
 
-			select {
-			case toDelete <- &PackageVersion{
-				Version:     *pv.Name,
-				PackageName: packageName,
-				ID:          *pv.ID,
-			}:
-			case <-ctx.Done():
-				return ctx.Err()
+			a.elect(packageName, pv, RuleIndex, elected, &pending)
+		}
+	}
+
+	for _, version := range untaggedVersions {
+		if _, ok := elected[*version.ID]; ok {
+			continue
+		}
+
+		if _, ok := attached[*version.Name]; ok {
+			a.Logger.V(1).Info("skip untagged package version as it is attached to a surviving tag", "package", packageName, "version", *version.Name, "id", *version.ID)
+			a.keep(packageName, version, RuleAttached)
+			continue
+		}
+
+		if _, ok := reachable[*version.Name]; ok {
+			a.Logger.V(1).Info("skip untagged package version as it is still referenced by a surviving tag", "package", packageName, "version", *version.Name, "id", *version.ID)
+			a.keep(packageName, version, RuleReferenced)
+			continue
+		}
+
+		a.elect(packageName, version, RuleUntagged, elected, &pending)
+	}
+
+	if a.CompanionArtifacts && a.PackageType == "container" {
+		if err := a.electCompanions(ctx, packageName, versions, packages, companions, protected, elected, &pending); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, packageVersion := range pending {
+		for _, id := range manifests[packageVersion.Version] {
+			a.groups.join(id, packageVersion.ID)
+		}
+	}
+
+	if err := a.checkBudget(packageName, len(pending), len(versions)); err != nil {
+		a.keepElected(pending, RuleDeletionBudget)
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// electCompanions elects the signatures, attestations and SBOMs of all elected versions.
+// Companion artifacts are found by the cosign tag convention (sha256-<digest>.sig) and the OCI referrers API.
+// Companion artifacts of surviving versions are kept.
+func (a *RetentionManager) electCompanions(ctx context.Context, packageName string, versions []*github.PackageVersion, packages map[string]*github.PackageVersion, companions []*github.PackageVersion, protected map[int64]Rule, elected map[int64]struct{}, pending *[]*PackageVersion) error {
+	for _, version := range companions {
+		subject, _ := companionSubject(version)
+		if _, ok := elected[*packages[subject].ID]; !ok {
+			a.Logger.V(1).Info("skip companion package version as its subject survives", "package", packageName, "version", *version.Name, "id", *version.ID, "subject", subject)
+			a.keep(packageName, version, RuleAttached)
+			continue
+		}
+
+		a.elect(packageName, version, RuleCompanion, elected, pending)
+		a.groups.join(*packages[subject].ID, *version.ID)
+	}
+
+	var queue []string
+	for _, version := range versions {
+		if _, ok := elected[*version.ID]; ok {
+			queue = append(queue, *version.Name)
+		}
+	}
+
+	for len(queue) > 0 {
+		digest := queue[0]
+		queue = queue[1:]
+
+		referrers, err := a.referrers(ctx, packageName, digest)
+		if err != nil {
+			return err
+		}
+
+		for _, referrer := range referrers {
+			pv, ok := packages[referrer]
+			if !ok {
+				continue
+			}
+
+			if _, ok := elected[*pv.ID]; ok {
+				continue
+			}
+
+			if reason, ok := protected[*pv.ID]; ok {
+				a.Logger.V(1).Info("skip referrer package version as it is protected", "package", packageName, "version", *pv.Name, "id", *pv.ID, "reason", reason)
+				a.keep(packageName, pv, reason)
+				continue
 			}
+
+			a.elect(packageName, pv, RuleReferrer, elected, pending)
+			a.groups.join(*packages[digest].ID, *pv.ID)
+
+			queue = append(queue, referrer)
 		}
 	}
 
 	return nil
 }
 
+// elect marks a package version as elected and adds it to the pending deletions of the package.
+func (a *RetentionManager) elect(packageName string, version *github.PackageVersion, rule Rule, elected map[int64]struct{}, pending *[]*PackageVersion) {
+	a.Logger.Info("package elected for deletion", "package", packageName, "version", *version.Name, "id", *version.ID, "rule", rule)
+	a.record(packageName, version, DecisionWouldDelete, rule)
+	elected[*version.ID] = struct{}{}
+
+	*pending = append(*pending, &PackageVersion{
+		Version:     *version.Name,
+		PackageName: packageName,
+		ID:          *version.ID,
+	})
+}
+
+// electionRule returns the rule which elects a version in the main evaluation.
+func (a *RetentionManager) electionRule() Rule {
+	switch {
+	case a.Age != 0:
+		return RuleAge
+	case a.VersionMatch != nil:
+		return RuleVersionMatch
+	default:
+		return RuleAll
+	}
+}
+
+// untaggedMode returns the configured UntaggedMode.
+// By default untagged versions are only removed directly if no VersionMatch is set.
+func (a *RetentionManager) untaggedMode() UntaggedMode {
+	if a.Untagged != "" {
+		return a.Untagged
+	}
+
+	if a.VersionMatch != nil {
+		return UntaggedKeep
+	}
+
+	return UntaggedDelete
+}
+
+// survivingReferences builds the set of manifest digests which are still referenced by
+// any tagged version of the package which is not elected for deletion.
+// If CompanionArtifacts is enabled it also returns the digests of the artifacts attached to these versions.
+func (a *RetentionManager) survivingReferences(ctx context.Context, packageName string, versions []*github.PackageVersion, elected map[int64]struct{}) (map[string]struct{}, map[string]struct{}, error) {
+	reachable := make(map[string]struct{})
+	attached := make(map[string]struct{})
+
+	for _, version := range versions {
+		if _, ok := elected[*version.ID]; ok {
+			continue
+		}
+
+		if len(tagsOf(version)) == 0 {
+			continue
+		}
+
+		// Signatures, attestations and SBOMs are no releases of their own
+		if _, ok := companionSubject(version); ok {
+			continue
+		}
+
+		digests, err := a.garbageCollectManifests(ctx, packageName, version)
+		if err != nil {
+			return reachable, attached, err
+		}
+
+		for _, digest := range digests {
+			reachable[digest] = struct{}{}
+		}
+
+		if !a.CompanionArtifacts {
+			continue
+		}
+
+		for _, digest := range append([]string{*version.Name}, digests...) {
+			referrers, err := a.referrers(ctx, packageName, digest)
+			if err != nil {
+				return reachable, attached, err
+			}
+
+			for _, referrer := range referrers {
+				attached[referrer] = struct{}{}
+			}
+		}
+	}
+
+	return reachable, attached, nil
+}
+
+// maxIndexDepth limits how deep nested image indexes are traversed.
+const maxIndexDepth = 10
+
 func (a *RetentionManager) garbageCollectManifests(ctx context.Context, packageName string, packageVersion *github.PackageVersion) ([]string, error) {
 	var tags []string
-	tagName := packageVersion.Metadata.Container.Tags[0]
-	imageRef, err := name.ParseReference(fmt.Sprintf("ghcr.io/%s/%s:%s", a.OrganizationName, packageName, tagName))
-	if err != nil {
-		return tags, err
+	// Untagged versions are resolved by their manifest digest
+	reference := fmt.Sprintf("%s@%s", a.repository(packageName), *packageVersion.Name)
+	if versionTags := tagsOf(packageVersion); len(versionTags) > 0 {
+		reference = fmt.Sprintf("%s:%s", a.repository(packageName), versionTags[0])
 	}
 
-	opts := []remote.Option{
-		remote.WithAuth(&authn.Basic{
-			Username: "ghcr",
-			Password: a.Token,
-		}),
-		remote.WithTransport(a.ContainerRegistryTransport),
+	imageRef, err := name.ParseReference(reference)
+	if err != nil {
+		return tags, err
 	}
 
-	descriptor, err := remote.Head(imageRef, opts...)
+	opts := a.registryOptions(ctx)
+	var descriptor *v1.Descriptor
+	err = a.retry(ctx, "head manifest", func() (*github.Response, error) {
+		descriptor, err = remote.Head(imageRef, opts...)
+		return nil, err
+	})
 
 	if err != nil {
 		return tags, err
 	}
 
-	if descriptor.MediaType != types.OCIImageIndex {
+	if !descriptor.MediaType.IsIndex() {
 		return tags, nil
 	}
 
-	index, err := remote.Index(imageRef, opts...)
+	// Child indexes are fetched lazily while walking, hence the whole walk is retried.
+	err = a.retry(ctx, "walk image index", func() (*github.Response, error) {
+		index, err := remote.Index(imageRef, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		visited := map[string]struct{}{
+			descriptor.Digest.String(): {},
+		}
+
+		tags, err = a.walkIndex(index, 1, visited)
+		return nil, err
+	})
 
+	return tags, err
+}
+
+// referrers returns the digests of all artifacts which refer to the given digest using the OCI referrers API.
+func (a *RetentionManager) referrers(ctx context.Context, packageName, digest string) ([]string, error) {
+	var digests []string
+	ref, err := name.NewDigest(fmt.Sprintf("%s@%s", a.repository(packageName), digest))
 	if err != nil {
-		return tags, err
+		// Not a manifest digest, nothing can refer to it
+		return digests, nil
+	}
+
+	var manifest *v1.IndexManifest
+	err = a.retry(ctx, "list referrers", func() (*github.Response, error) {
+		index, err := remote.Referrers(ref, a.registryOptions(ctx)...)
+		if err != nil {
+			return nil, err
+		}
+
+		manifest, err = index.IndexManifest()
+		return nil, err
+	})
+
+	if err != nil {
+		return digests, err
+	}
+
+	for _, descriptor := range manifest.Manifests {
+		digests = append(digests, descriptor.Digest.String())
+	}
+
+	return digests, nil
+}
+
+// DefaultRegistryHost is the container registry of github.com.
+const DefaultRegistryHost = "ghcr.io"
+
+func (a *RetentionManager) repository(packageName string) string {
+	registryHost := a.RegistryHost
+	if registryHost == "" {
+		registryHost = DefaultRegistryHost
+	}
+
+	return fmt.Sprintf("%s/%s/%s", registryHost, a.owner, packageName)
+}
+
+// registryAuth returns the registry credentials. If a TokenSource is configured
+// the current (possibly refreshed) token is used for each registry request.
+func (a *RetentionManager) registryAuth() authn.Authenticator {
+	if a.TokenSource == nil {
+		return &authn.Basic{
+			Username: "ghcr",
+			Password: a.Token,
+		}
+	}
+
+	return &tokenAuthenticator{source: a.TokenSource}
+}
+
+type tokenAuthenticator struct {
+	source oauth2.TokenSource
+}
+
+func (t *tokenAuthenticator) Authorization() (*authn.AuthConfig, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return &authn.AuthConfig{
+		Username: "ghcr",
+		Password: token.AccessToken,
+	}, nil
+}
+
+func (a *RetentionManager) registryOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithAuth(a.registryAuth()),
+		remote.WithTransport(a.ContainerRegistryTransport),
+		remote.WithContext(ctx),
+		// Failed requests are retried by the manager itself
+		remote.WithRetryStatusCodes(),
+		remote.WithRetryPredicate(func(error) bool { return false }),
+	}
+}
+
+// walkIndex returns the digests of all manifests reachable from the given index.
+// Nested indexes are traversed recursively, each digest is only visited once.
+func (a *RetentionManager) walkIndex(index v1.ImageIndex, depth int, visited map[string]struct{}) ([]string, error) {
+	var tags []string
+	if depth > maxIndexDepth {
+		return tags, fmt.Errorf("image index nesting exceeds the maximum depth of %d", maxIndexDepth)
 	}
 
 	manifest, err := index.IndexManifest()
@@ -188,7 +928,29 @@ func (a *RetentionManager) garbageCollectManifests(ctx context.Context, packageN
 	}
 
 	for _, descriptor := range manifest.Manifests {
-		tags = append(tags, descriptor.Digest.String())
+		digest := descriptor.Digest.String()
+		if _, ok := visited[digest]; ok {
+			continue
+		}
+
+		visited[digest] = struct{}{}
+		tags = append(tags, digest)
+
+		if !descriptor.MediaType.IsIndex() {
+			continue
+		}
+
+		child, err := index.ImageIndex(descriptor.Digest)
+		if err != nil {
+			return tags, err
+		}
+
+		children, err := a.walkIndex(child, depth+1, visited)
+		if err != nil {
+			return tags, err
+		}
+
+		tags = append(tags, children...)
 	}
 
 	return tags, nil
@@ -203,9 +965,12 @@ func (a *RetentionManager) deletePackages(ctx context.Context, toDelete chan *Pa
 			continue
 		}
 
-		_, err := a.GithubClient.Organizations.PackageDeleteVersion(ctx, a.OrganizationName, a.PackageType, url.PathEscape(packageVersion.PackageName), packageVersion.ID)
-		if err != nil {
-			return deleted, err
+		if err := a.deleteVersion(ctx, packageVersion); err != nil {
+			if err := a.failed(fmt.Errorf("package %s version %s (%d): %w", packageVersion.PackageName, packageVersion.Version, packageVersion.ID, err)); err != nil {
+				return deleted, err
+			}
+
+			continue
 		}
 
 		deleted = append(deleted, packageVersion)
@@ -213,8 +978,117 @@ func (a *RetentionManager) deletePackages(ctx context.Context, toDelete chan *Pa
 
 	return deleted, nil
 }
+
+// protectedVersions returns the IDs of all versions which must not be deleted
+// together with the rule which protects them.
+func (a *RetentionManager) protectedVersions(packageName string, versions []*github.PackageVersion) map[int64]Rule {
+	protected := make(map[int64]Rule)
+
+	if a.ProtectMatch != nil {
+		for _, version := range versions {
+			if a.protectMatch(version) {
+				protected[*version.ID] = RuleProtectMatch
+				a.Logger.Info("package version protected", "package", packageName, "version", *version.Name, "id", *version.ID, "tags", tagsOf(version), "reason", RuleProtectMatch)
+			}
+		}
+	}
+
+	var candidates []*github.PackageVersion
+	for _, version := range versions {
+		if a.VersionMatch != nil && !a.matchVersion(version) {
+			continue
+		}
+
+		candidates = append(candidates, version)
+	}
+
+	for id := range a.keepLast(candidates) {
+		protected[id] = RuleKeepLast
+	}
+
+	if a.Semver != nil {
+		for id := range a.Semver.keep(a.PackageType, candidates) {
+			if _, ok := protected[id]; !ok {
+				protected[id] = RuleSemver
+			}
+		}
+	}
+
+	for _, version := range candidates {
+		if reason, ok := protected[*version.ID]; ok && reason != RuleProtectMatch {
+			a.Logger.Info("package version protected", "package", packageName, "version", *version.Name, "id", *version.ID, "tags", tagsOf(version), "reason", reason)
+		}
+	}
+
+	return protected
+}
+
+// keepLast returns the IDs of the KeepLast newest versions.
+// For containers only tagged versions are taken into account.
+func (a *RetentionManager) keepLast(versions []*github.PackageVersion) map[int64]struct{} {
+	keep := make(map[int64]struct{})
+	if a.KeepLast <= 0 {
+		return keep
+	}
+
+	var candidates []*github.PackageVersion
+	for _, version := range versions {
+		if a.PackageType == "container" && len(tagsOf(version)) == 0 {
+			continue
+		}
+
+		// Signatures, attestations and SBOMs are no releases of their own
+		if _, ok := companionSubject(version); ok {
+			continue
+		}
+
+		candidates = append(candidates, version)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return createdAt(candidates[i]).After(createdAt(candidates[j]))
+	})
+
+	for i, version := range candidates {
+		if i >= a.KeepLast {
+			break
+		}
+
+		keep[*version.ID] = struct{}{}
+	}
+
+	return keep
+}
+
+// protectMatch returns true if either the version name or any of its container tags matches ProtectMatch.
+func (a *RetentionManager) protectMatch(version *github.PackageVersion) bool {
+	if a.ProtectMatch == nil {
+		return false
+	}
+
+	if a.ProtectMatch.MatchString(*version.Name) {
+		return true
+	}
+
+	for _, tagName := range tagsOf(version) {
+		if a.ProtectMatch.MatchString(tagName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *RetentionManager) matchVersion(version *github.PackageVersion) bool {
+	if a.PackageType == "container" {
+		return a.matchContainer(version)
+	}
+
+	return a.VersionMatch.MatchString(*version.Name)
+}
+
 func (a *RetentionManager) matchContainer(version *github.PackageVersion) bool {
-	if version.Metadata == nil || version.Metadata.Container.Tags == nil {
+	if version.Metadata == nil || version.Metadata.Container == nil || version.Metadata.Container.Tags == nil {
 		return false
 	}
 
@@ -236,17 +1110,13 @@ func (a *RetentionManager) getAllVersionsForPackage(ctx context.Context, package
 	}
 
 	for {
-		versions, resp, err := a.GithubClient.Organizations.PackageGetAllVersions(ctx, a.OrganizationName, a.PackageType, url.PathEscape(packageName), opts)
+		versions, resp, err := a.listPackageVersions(ctx, packageName, opts)
 		if err != nil {
 			return packageVersions, err
 		}
 
 		packageVersions = append(packageVersions, versions...)
 
-		if a.MaxVersions != 0 && len(packageVersions) >= a.MaxVersions {
-			break
-		}
-
 		if resp.NextPage == 0 {
 			break
 		}
@@ -258,3 +1128,44 @@ func (a *RetentionManager) getAllVersionsForPackage(ctx context.Context, package
 	return packageVersions, nil
 
 }
+
+var companionTag = regexp.MustCompile(`^sha256-([a-f0-9]{64})(\.(sig|att|sbom))?$`)
+
+// companionSubject returns the digest of the image a cosign signature, attestation or SBOM belongs to.
+func companionSubject(version *github.PackageVersion) (string, bool) {
+	for _, tagName := range tagsOf(version) {
+		if m := companionTag.FindStringSubmatch(tagName); m != nil {
+			return "sha256:" + m[1], true
+		}
+	}
+
+	return "", false
+}
+
+func createdAt(version *github.PackageVersion) time.Time {
+	if version.CreatedAt != nil {
+		return version.CreatedAt.Time
+	}
+
+	if version.UpdatedAt != nil {
+		return version.UpdatedAt.Time
+	}
+
+	return time.Time{}
+}
+
+func updatedAt(version *github.PackageVersion) time.Time {
+	if version.UpdatedAt != nil {
+		return version.UpdatedAt.Time
+	}
+
+	return time.Time{}
+}
+
+func tagsOf(version *github.PackageVersion) []string {
+	if version.Metadata == nil || version.Metadata.Container == nil {
+		return nil
+	}
+
+	return version.Metadata.Container.Tags
+}
@@ -1,7 +1,11 @@
 package ghpackage
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"regexp"
@@ -10,9 +14,13 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/go-github/v53/github"
 	"github.com/migueleliasweb/go-github-mock/src/mock"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
 )
 
 type runTest struct {
@@ -21,6 +29,11 @@ type runTest struct {
 	expected         []*PackageVersion
 }
 
+var (
+	nestedIndex       = indexManifest(types.OCIImageIndex, descriptor(types.OCIManifestSchema1, "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f"))
+	nestedIndexDigest = digestOf(nestedIndex)
+)
+
 func TestRun(t *testing.T) {
 	var tests = []runTest{
 		{
@@ -387,38 +400,1581 @@ func TestRun(t *testing.T) {
 				}
 			},
 		},
-	}
+		{
+			name: "The newest versions are kept if KeepLast is set",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "1.0.0",
+					ID:          1,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "1.0.0"
+					packageID1   int64 = 1
+					packageName2       = "1.1.0"
+					packageID2   int64 = 2
+					packageName3       = "1.2.0"
+					packageID3   int64 = 3
+				)
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			a := test.RetentionManager()
-			a.Logger = logr.Discard()
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "maven",
+					Age:              time.Second * 10,
+					KeepLast:         2,
+					OrganizationName: "myorg",
+					DryRun:           false,
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name:      &packageName3,
+									ID:        &packageID3,
+									CreatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName1,
+									ID:        &packageID1,
+									CreatedAt: &github.Timestamp{Time: time.Now().Add(-180 * time.Second)},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName2,
+									ID:        &packageID2,
+									CreatedAt: &github.Timestamp{Time: time.Now().Add(-120 * time.Second)},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "KeepLast only counts container versions which match VersionMatch",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-1",
+					ID:          1,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					packageName2       = "package-2"
+					packageID2   int64 = 2
+					packageName3       = "package-3"
+					packageID3   int64 = 3
+				)
 
-			remove, err := a.Run(context.TODO())
-			assert.Equal(t, test.expected, remove)
-			assert.NoError(t, err)
-		})
-	}
-}
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					VersionMatch:               regexp.MustCompile(`^pr-`),
+					Age:                        time.Second * 10,
+					KeepLast:                   1,
+					OrganizationName:           "myorg",
+					ContainerRegistryTransport: noIndexResponseTransport(),
+					DryRun:                     false,
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName3,
+									ID:   &packageID3,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"v1.0.0"},
+										},
+									},
+									CreatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name: &packageName2,
+									ID:   &packageID2,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"pr-2"},
+										},
+									},
+									CreatedAt: &github.Timestamp{Time: time.Now().Add(-120 * time.Second)},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-120 * time.Second)},
+								},
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"pr-1"},
+										},
+									},
+									CreatedAt: &github.Timestamp{Time: time.Now().Add(-180 * time.Second)},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-180 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Versions which have a tag matching ProtectMatch are not removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-2",
+					ID:          2,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					packageName2       = "package-2"
+					packageID2   int64 = 2
+				)
 
-type mockTransport struct {
-	responsePool []*http.Response
-}
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					VersionMatch:               regexp.MustCompile(`^pr-`),
+					ProtectMatch:               regexp.MustCompile(`^(latest|v.*)$`),
+					Age:                        time.Second * 10,
+					OrganizationName:           "myorg",
+					ContainerRegistryTransport: noIndexResponseTransport(),
+					DryRun:                     false,
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"pr-123", "latest"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name: &packageName2,
+									ID:   &packageID2,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"pr-124"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName2,
+								ID:   &packageID2,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Versions matching ProtectMatch are not removed regardless of age",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "1.0.0-SNAPSHOT",
+					ID:          2,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "1.0.0"
+					packageID1   int64 = 1
+					packageName2       = "1.0.0-SNAPSHOT"
+					packageID2   int64 = 2
+				)
 
-func newMockTransport(r ...*http.Response) http.RoundTripper {
-	return &mockTransport{
-		responsePool: r,
-	}
-}
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "maven",
+					ProtectMatch:     regexp.MustCompile(`^[0-9.]+$`),
+					Age:              time.Second * 10,
+					OrganizationName: "myorg",
+					DryRun:           false,
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name:      &packageName1,
+									ID:        &packageID1,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName2,
+									ID:        &packageID2,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName2,
+								ID:   &packageID2,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Referenced packages which are still referenced by a surviving oci.index are not removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-1",
+					ID:          1,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f",
+					ID:          4,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					packageName2       = "package-2"
+					packageID2   int64 = 2
+					packageName3       = "sha256:c131f961d7af9055d4ff68fad06e7e24c3ce0b971a99d700bc6ba4947b12da86"
+					packageID3   int64 = 3
+					packageName4       = "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f"
+					packageID4   int64 = 4
+				)
 
-func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	v := t.responsePool[0]
-	t.responsePool = t.responsePool[1:]
-	return v, nil
-}
+				var responses []*http.Response
+				responses = append(responses, indexResponses(types.OCIImageIndex, packageName3, packageName4)...)
+				responses = append(responses, indexResponses(types.OCIImageIndex, packageName3)...)
 
-func noIndexResponseTransport() http.RoundTripper {
-	manifest := `{
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					VersionMatch:               regexp.MustCompile(`^pr-`),
+					Age:                        time.Second * 10,
+					OrganizationName:           "myorg",
+					DryRun:                     false,
+					ContainerRegistryTransport: newMockTransport(responses...),
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"pr-1"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name: &packageName2,
+									ID:   &packageID2,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"v1.0.0"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName3,
+									ID:        &packageID3,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName4,
+									ID:        &packageID4,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+							&github.PackageVersion{
+								Name: &packageName4,
+								ID:   &packageID4,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Untagged packages which are not referenced by any tag are removed with UntaggedDeleteUnreferenced",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f",
+					ID:          3,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					packageName2       = "sha256:c131f961d7af9055d4ff68fad06e7e24c3ce0b971a99d700bc6ba4947b12da86"
+					packageID2   int64 = 2
+					packageName3       = "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f"
+					packageID3   int64 = 3
+				)
+
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					VersionMatch:               regexp.MustCompile(`^pr-`),
+					Untagged:                   UntaggedDeleteUnreferenced,
+					Age:                        time.Second * 10,
+					OrganizationName:           "myorg",
+					DryRun:                     false,
+					ContainerRegistryTransport: indexResponseTransport(types.OCIImageIndex, packageName2),
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"v1.0.0"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName2,
+									ID:        &packageID2,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName3,
+									ID:        &packageID3,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName3,
+								ID:   &packageID3,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Untagged packages are not removed with UntaggedKeep",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-1",
+					ID:          1,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					packageName2       = "sha256:c131f961d7af9055d4ff68fad06e7e24c3ce0b971a99d700bc6ba4947b12da86"
+					packageID2   int64 = 2
+				)
+
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "container",
+					Untagged:         UntaggedKeep,
+					Age:              time.Second * 10,
+					OrganizationName: "myorg",
+					DryRun:           false,
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"v1.0.0"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName2,
+									ID:        &packageID2,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Referenced packages in a docker manifest list package are also removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-1",
+					ID:          1,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:c131f961d7af9055d4ff68fad06e7e24c3ce0b971a99d700bc6ba4947b12da86",
+					ID:          2,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					packageName2       = "sha256:c131f961d7af9055d4ff68fad06e7e24c3ce0b971a99d700bc6ba4947b12da86"
+					packageID2   int64 = 2
+				)
+
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					VersionMatch:               regexp.MustCompile(`package`),
+					Age:                        time.Second * 10,
+					OrganizationName:           "myorg",
+					DryRun:                     false,
+					ContainerRegistryTransport: indexResponseTransport(types.DockerManifestList, packageName2),
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"package-1-index"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName2,
+									ID:        &packageID2,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+							&github.PackageVersion{
+								Name: &packageName2,
+								ID:   &packageID2,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Referenced packages in nested image indexes are also removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-1",
+					ID:          1,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:c131f961d7af9055d4ff68fad06e7e24c3ce0b971a99d700bc6ba4947b12da86",
+					ID:          2,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     nestedIndexDigest,
+					ID:          3,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f",
+					ID:          4,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					packageName2       = "sha256:c131f961d7af9055d4ff68fad06e7e24c3ce0b971a99d700bc6ba4947b12da86"
+					packageID2   int64 = 2
+					packageName3       = nestedIndexDigest
+					packageID3   int64 = 3
+					packageName4       = "sha256:b6e64b25771997b04f2cee5ee7a0f44886833a80d6e6e41e0c3f2696d253ee5f"
+					packageID4   int64 = 4
+				)
+
+				root := indexManifest(types.OCIImageIndex,
+					descriptor(types.OCIManifestSchema1, packageName2),
+					descriptor(types.OCIImageIndex, packageName3),
+				)
+
+				responses := tagResponses(types.OCIImageIndex, root)
+				responses = append(responses, manifestResponse(types.OCIImageIndex, nestedIndex))
+
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					VersionMatch:               regexp.MustCompile(`package`),
+					Age:                        time.Second * 10,
+					OrganizationName:           "myorg",
+					DryRun:                     false,
+					ContainerRegistryTransport: newMockTransport(responses...),
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"package-1-index"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName2,
+									ID:        &packageID2,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName3,
+									ID:        &packageID3,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName4,
+									ID:        &packageID4,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+							&github.PackageVersion{
+								Name: &packageName2,
+								ID:   &packageID2,
+							},
+							&github.PackageVersion{
+								Name: &packageName3,
+								ID:   &packageID3,
+							},
+							&github.PackageVersion{
+								Name: &packageName4,
+								ID:   &packageID4,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Companion artifacts of removed images are removed and those of surviving images are kept",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+					ID:          1,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee",
+					ID:          3,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc",
+					ID:          5,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+					packageID1   int64 = 1
+					packageName2       = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+					packageID2   int64 = 2
+					packageName3       = "sha256:eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"
+					packageID3   int64 = 3
+					packageName4       = "sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+					packageID4   int64 = 4
+					packageName5       = "sha256:cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+					packageID5   int64 = 5
+					packageName6       = "sha256:dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd"
+					packageID6   int64 = 6
+				)
+
+				return &RetentionManager{
+					PackageNames:       []string{"mypackage"},
+					PackageType:        "container",
+					VersionMatch:       regexp.MustCompile(`^pr-`),
+					Untagged:           UntaggedDelete,
+					CompanionArtifacts: true,
+					Age:                time.Second * 10,
+					OrganizationName:   "myorg",
+					DryRun:             false,
+					ContainerRegistryTransport: registryTransport{
+						"HEAD /v2/myorg/mypackage/manifests/pr-1":           headResponse(types.OCIManifestSchema1, packageName1),
+						"HEAD /v2/myorg/mypackage/manifests/v1.0.0":         headResponse(types.OCIManifestSchema1, packageName2),
+						"GET /v2/myorg/mypackage/referrers/" + packageName1: referrersResponse(packageName5),
+						"GET /v2/myorg/mypackage/referrers/" + packageName2: referrersResponse(packageName6),
+					},
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"pr-1"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name: &packageName2,
+									ID:   &packageID2,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"v1.0.0"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name: &packageName3,
+									ID:   &packageID3,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"sha256-aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.sig"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name: &packageName4,
+									ID:   &packageID4,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"sha256-bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb.att"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName5,
+									ID:        &packageID5,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+								{
+									Name:      &packageName6,
+									ID:        &packageID6,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+							&github.PackageVersion{
+								Name: &packageName5,
+								ID:   &packageID5,
+							},
+							&github.PackageVersion{
+								Name: &packageName3,
+								ID:   &packageID3,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Untagged child manifests of an index protected by ProtectMatch are not removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("3", 64),
+					ID:          3,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("4", 64),
+					ID:          4,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				child := "sha256:" + strings.Repeat("2", 64)
+
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					ProtectMatch:               regexp.MustCompile(`^v2`),
+					Age:                        time.Second * 10,
+					OrganizationName:           "myorg",
+					ContainerRegistryTransport: indexResponseTransport(types.OCIImageIndex, child),
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								agedVersion(1, "sha256:"+strings.Repeat("1", 64), time.Minute, "v2.0.0"),
+								agedVersion(2, child, time.Minute),
+								agedVersion(3, "sha256:"+strings.Repeat("3", 64), 2*time.Minute, "v1.0.0"),
+								agedVersion(4, "sha256:"+strings.Repeat("4", 64), 2*time.Minute),
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							nil,
+							nil,
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Child manifests of image indexes kept by KeepLast are not removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("3", 64),
+					ID:          3,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("6", 64),
+					ID:          6,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					child1 = "sha256:" + strings.Repeat("4", 64)
+					child2 = "sha256:" + strings.Repeat("5", 64)
+					index1 = indexManifest(types.OCIImageIndex, descriptor(types.OCIManifestSchema1, child1))
+					index2 = indexManifest(types.OCIImageIndex, descriptor(types.OCIManifestSchema1, child2))
+				)
+
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "container",
+					KeepLast:         2,
+					Age:              time.Second * 10,
+					OrganizationName: "myorg",
+					ContainerRegistryTransport: registryTransport{
+						"HEAD /v2/myorg/mypackage/manifests/v3.0.0": headResponse(types.OCIImageIndex, digestOf(index1)),
+						"GET /v2/myorg/mypackage/manifests/v3.0.0":  func() *http.Response { return manifestResponse(types.OCIImageIndex, index1) },
+						"HEAD /v2/myorg/mypackage/manifests/v2.0.0": headResponse(types.OCIImageIndex, digestOf(index2)),
+						"GET /v2/myorg/mypackage/manifests/v2.0.0":  func() *http.Response { return manifestResponse(types.OCIImageIndex, index2) },
+					},
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								agedVersion(1, digestOf(index1), time.Minute, "v3.0.0"),
+								agedVersion(2, digestOf(index2), 2*time.Minute, "v2.0.0"),
+								agedVersion(3, "sha256:"+strings.Repeat("3", 64), 3*time.Minute, "v1.0.0"),
+								agedVersion(4, child1, time.Minute),
+								agedVersion(5, child2, 2*time.Minute),
+								agedVersion(6, "sha256:"+strings.Repeat("6", 64), 3*time.Minute),
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							nil,
+							nil,
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "KeepLast does not count cosign signatures, attestations and SBOMs",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("1", 64),
+					ID:          1,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("3", 64),
+					ID:          3,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("4", 64),
+					ID:          4,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "container",
+					KeepLast:         1,
+					Age:              time.Second * 10,
+					OrganizationName: "myorg",
+					ContainerRegistryTransport: registryTransport{
+						"HEAD /v2/myorg/mypackage/manifests/v2.0.0": headResponse(types.OCIManifestSchema1, "sha256:"+strings.Repeat("2", 64)),
+					},
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								agedVersion(1, "sha256:"+strings.Repeat("1", 64), 3*time.Minute, "v1.0.0"),
+								agedVersion(2, "sha256:"+strings.Repeat("2", 64), 2*time.Minute, "v2.0.0"),
+								agedVersion(3, "sha256:"+strings.Repeat("3", 64), time.Minute, "sha256-"+strings.Repeat("1", 64)+".sig"),
+								agedVersion(4, "sha256:"+strings.Repeat("4", 64), time.Minute, "sha256-"+strings.Repeat("1", 64)+".att"),
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							nil,
+							nil,
+							nil,
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Semver does not protect companion artifacts",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("1", 64),
+					ID:          1,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("3", 64),
+					ID:          3,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				return &RetentionManager{
+					PackageNames:               []string{"mypackage"},
+					PackageType:                "container",
+					Semver:                     &SemverPolicy{Keep: 1},
+					CompanionArtifacts:         true,
+					Age:                        time.Second * 10,
+					OrganizationName:           "myorg",
+					ContainerRegistryTransport: registryTransport{},
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								agedVersion(1, "sha256:"+strings.Repeat("1", 64), 3*time.Minute, "v1.0.0"),
+								agedVersion(2, "sha256:"+strings.Repeat("2", 64), 2*time.Minute, "v1.0.1"),
+								agedVersion(3, "sha256:"+strings.Repeat("3", 64), 3*time.Minute, "sha256-"+strings.Repeat("1", 64)+".sig"),
+								agedVersion(4, "sha256:"+strings.Repeat("4", 64), 2*time.Minute, "sha256-"+strings.Repeat("2", 64)+".sig"),
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							nil,
+							nil,
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "KeepLast counts container versions protected by ProtectMatch",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("1", 64),
+					ID:          1,
+				},
+				{
+					PackageName: "mypackage",
+					Version:     "sha256:" + strings.Repeat("2", 64),
+					ID:          2,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "container",
+					VersionMatch:     regexp.MustCompile(`^v`),
+					ProtectMatch:     regexp.MustCompile(`^v3`),
+					KeepLast:         1,
+					Age:              time.Second * 10,
+					OrganizationName: "myorg",
+					ContainerRegistryTransport: registryTransport{
+						"HEAD /v2/myorg/mypackage/manifests/v1.0.0": headResponse(types.OCIManifestSchema1, "sha256:"+strings.Repeat("1", 64)),
+						"HEAD /v2/myorg/mypackage/manifests/v2.0.0": headResponse(types.OCIManifestSchema1, "sha256:"+strings.Repeat("2", 64)),
+					},
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								agedVersion(1, "sha256:"+strings.Repeat("1", 64), 3*time.Minute, "v1.0.0"),
+								agedVersion(2, "sha256:"+strings.Repeat("2", 64), 2*time.Minute, "v2.0.0"),
+								agedVersion(3, "sha256:"+strings.Repeat("3", 64), time.Minute, "v3.0.0"),
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							nil,
+							nil,
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Packages owned by a user are removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-1",
+					ID:          1,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+				)
+
+				return &RetentionManager{
+					PackageNames: []string{"mypackage"},
+					PackageType:  "maven",
+					Age:          time.Second * 10,
+					UserName:     "myuser",
+					DryRun:       false,
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetUsersPackagesVersionsByUsernameByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name:      &packageName1,
+									ID:        &packageID1,
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteUsersPackagesVersionsByUsernameByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+						),
+					)),
+				}
+			},
+		},
+		{
+			name: "Packages owned by the authenticated user are removed",
+			expected: []*PackageVersion{
+				{
+					PackageName: "mypackage",
+					Version:     "package-1",
+					ID:          1,
+				},
+			},
+			RetentionManager: func() *RetentionManager {
+				var (
+					packageName1       = "package-1"
+					packageID1   int64 = 1
+					login              = "MyUser"
+				)
+
+				return &RetentionManager{
+					PackageNames: []string{"mypackage"},
+					PackageType:  "container",
+					VersionMatch: regexp.MustCompile(`^pr-`),
+					Age:          time.Second * 10,
+					DryRun:       false,
+					ContainerRegistryTransport: registryTransport{
+						"HEAD /v2/myuser/mypackage/manifests/pr-1": headResponse(types.OCIManifestSchema1, "sha256:a60d0af675b0bad03ebdb529ed1b6009604063136f30516568028008c221e62d"),
+					},
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetUser,
+							&github.User{
+								Login: &login,
+							},
+						),
+						mock.WithRequestMatch(
+							mock.GetUserPackagesVersionsByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								{
+									Name: &packageName1,
+									ID:   &packageID1,
+									Metadata: &github.PackageMetadata{
+										Container: &github.PackageContainerMetadata{
+											Tags: []string{"pr-1"},
+										},
+									},
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+								},
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteUserPackagesVersionsByPackageTypeByPackageNameByPackageVersionId,
+							&github.PackageVersion{
+								Name: &packageName1,
+								ID:   &packageID1,
+							},
+						),
+					)),
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := test.RetentionManager()
+			a.Logger = logr.Discard()
+
+			remove, err := a.Run(context.TODO())
+			assert.Equal(t, test.expected, remove)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// agedVersion returns a package version which has been created and updated the given time ago.
+func agedVersion(id int64, name string, age time.Duration, tags ...string) *github.PackageVersion {
+	version := newVersion(id, name, tags...)
+	version.CreatedAt = &github.Timestamp{Time: time.Now().Add(-age)}
+	version.UpdatedAt = &github.Timestamp{Time: time.Now().Add(-age)}
+	return version
+}
+
+func TestRunConcurrently(t *testing.T) {
+	var (
+		packageNames []string
+		expected     []*PackageVersion
+	)
+
+	for i := 0; i < 5; i++ {
+		packageNames = append(packageNames, fmt.Sprintf("package-%d", i))
+	}
+
+	// Every package has 20 versions, all except the newest one are older than age.
+	versions := make(map[string][]*github.PackageVersion)
+	for p, packageName := range packageNames {
+		for i := 0; i < 20; i++ {
+			version := &github.PackageVersion{
+				Name:      github.String(fmt.Sprintf("%d.0.0", i)),
+				ID:        github.Int64(int64(p*100 + i)),
+				UpdatedAt: &github.Timestamp{Time: time.Now().Add(-time.Duration(i) * time.Hour)},
+			}
+
+			versions[packageName] = append(versions[packageName], version)
+			if i == 0 {
+				continue
+			}
+
+			expected = append(expected, &PackageVersion{
+				PackageName: packageName,
+				Version:     *version.Name,
+				ID:          *version.ID,
+			})
+		}
+	}
+
+	a := &RetentionManager{
+		PackageNames:     packageNames,
+		PackageType:      "maven",
+		OrganizationName: "myorg",
+		Age:              time.Minute,
+		Concurrency:      4,
+		Logger:           logr.Discard(),
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					packageName := strings.Split(r.URL.Path, "/")[5]
+					_, _ = w.Write(mock.MustMarshal(versions[packageName]))
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			),
+		)),
+	}
+
+	removed, err := a.Run(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, removed)
+	assert.Len(t, a.Evaluations(), len(packageNames)*20)
+}
+
+func TestRunContinueOnError(t *testing.T) {
+	var (
+		packageName1       = "package-1"
+		packageID1   int64 = 1
+		packageName2       = "package-2"
+		packageID2   int64 = 2
+	)
+
+	a := &RetentionManager{
+		PackageNames:     []string{"forbidden", "mypackage"},
+		PackageType:      "maven",
+		OrganizationName: "myorg",
+		Age:              time.Second * 10,
+		ContinueOnError:  true,
+		Logger:           logr.Discard(),
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/orgs/myorg/packages/maven/forbidden/versions" {
+						mock.WriteError(w, http.StatusForbidden, "forbidden")
+						return
+					}
+
+					_, _ = w.Write(mock.MustMarshal([]*github.PackageVersion{
+						{
+							Name:      &packageName1,
+							ID:        &packageID1,
+							UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+						},
+						{
+							Name:      &packageName2,
+							ID:        &packageID2,
+							UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+						},
+					}))
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if strings.HasSuffix(r.URL.Path, "/1") {
+						mock.WriteError(w, http.StatusForbidden, "forbidden")
+						return
+					}
+
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			),
+		)),
+	}
+
+	removed, err := a.Run(context.TODO())
+	assert.Equal(t, []*PackageVersion{
+		{
+			PackageName: "mypackage",
+			Version:     packageName2,
+			ID:          packageID2,
+		},
+	}, removed)
+
+	assert.Error(t, err)
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 2)
+	assert.Contains(t, err.Error(), "package forbidden:")
+	assert.Contains(t, err.Error(), "package mypackage version package-1 (1):")
+
+	var failures []string
+	for _, evaluation := range a.Evaluations() {
+		if evaluation.Decision == DecisionError {
+			failures = append(failures, fmt.Sprintf("%s/%d", evaluation.PackageName, evaluation.ID))
+			assert.NotEmpty(t, evaluation.Error)
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"forbidden/0", "mypackage/1"}, failures)
+}
+
+func TestRunAllAbortsRemainingManagers(t *testing.T) {
+	// manager returns a manager of a package with two old versions, listing or deleting fails with the given status codes.
+	manager := func(packageName string, continueOnError bool, listStatus, deleteStatus int) *RetentionManager {
+		return &RetentionManager{
+			PackageNames:     []string{packageName, "other"},
+			PackageType:      "maven",
+			OrganizationName: "myorg",
+			Age:              time.Minute,
+			ContinueOnError:  continueOnError,
+			Logger:           logr.Discard(),
+			GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+				mock.WithRequestMatchHandler(
+					mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if strings.Split(r.URL.Path, "/")[5] == "other" && listStatus != 0 {
+							mock.WriteError(w, listStatus, "failed")
+							return
+						}
+
+						var versions []*github.PackageVersion
+						if strings.Split(r.URL.Path, "/")[5] == packageName {
+							for i := int64(1); i <= 2; i++ {
+								versions = append(versions, &github.PackageVersion{
+									Name:      github.String(fmt.Sprintf("%d.0.0", i)),
+									ID:        github.Int64(i),
+									UpdatedAt: &github.Timestamp{Time: time.Now().Add(-time.Duration(i) * time.Hour)},
+								})
+							}
+						}
+
+						_, _ = w.Write(mock.MustMarshal(versions))
+					}),
+				),
+				mock.WithRequestMatchHandler(
+					mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if deleteStatus != 0 {
+							mock.WriteError(w, deleteStatus, "failed")
+							return
+						}
+
+						w.WriteHeader(http.StatusNoContent)
+					}),
+				),
+			)),
+		}
+	}
+
+	evaluate := func(a *RetentionManager) ([]*PackageVersion, error) {
+		return a.Evaluate(context.TODO())
+	}
+
+	t.Run("Collected failures are returned if the run budget is exceeded", func(t *testing.T) {
+		base := &RetentionManager{MaxDeletions: 1, ContinueOnError: true, Logger: logr.Discard()}
+		a := manager("mypackage", true, http.StatusForbidden, 0)
+
+		removed, failures := RunAll(context.TODO(), base, []*RetentionManager{a}, evaluate)
+		assert.Empty(t, removed)
+		assert.Len(t, failures, 2)
+		assert.ErrorIs(t, failures[0], ErrDeletionBudgetExceeded)
+		assert.Contains(t, failures[1].Error(), "package other:")
+	})
+
+	t.Run("Managers after a failed deletion are aborted", func(t *testing.T) {
+		base := &RetentionManager{Logger: logr.Discard()}
+		managers := []*RetentionManager{
+			manager("package-1", false, 0, http.StatusForbidden),
+			manager("package-2", false, 0, 0),
+		}
+
+		removed, failures := RunAll(context.TODO(), base, managers, evaluate)
+		assert.Empty(t, removed)
+		assert.Len(t, failures, 1)
+		assert.Contains(t, failures[0].Error(), "package package-1 version")
+
+		for _, evaluation := range managers[1].Evaluations() {
+			assert.Equal(t, DecisionKept, evaluation.Decision)
+		}
+	})
+}
+
+func TestRunMaxVersions(t *testing.T) {
+	// The API lists the newest versions first, the oldest versions are on the last page.
+	var pages [][]*github.PackageVersion
+	for page := 0; page < 3; page++ {
+		var versions []*github.PackageVersion
+		for i := 0; i < 2; i++ {
+			id := int64(page*2 + i)
+			versions = append(versions, &github.PackageVersion{
+				Name:      github.String(fmt.Sprintf("%d.0.0", id)),
+				ID:        github.Int64(id),
+				UpdatedAt: &github.Timestamp{Time: time.Now().Add(-time.Duration(id+1) * time.Hour)},
+			})
+		}
+
+		pages = append(pages, versions)
+	}
+
+	var (
+		child1 = "sha256:" + strings.Repeat("1", 64)
+		child2 = "sha256:" + strings.Repeat("2", 64)
+		orphan = "sha256:" + strings.Repeat("3", 64)
+		index  = indexManifest(types.OCIImageIndex, descriptor(types.OCIManifestSchema1, child1), descriptor(types.OCIManifestSchema1, child2))
+	)
+
+	var tests = []struct {
+		name             string
+		RetentionManager func() *RetentionManager
+		expected         []*PackageVersion
+		rules            map[int64]Rule
+	}{
+		{
+			name: "The oldest elected versions are deleted",
+			RetentionManager: func() *RetentionManager {
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "maven",
+					OrganizationName: "myorg",
+					Age:              time.Minute,
+					MaxVersions:      2,
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatchPages(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							pages[0], pages[1], pages[2],
+						),
+						mock.WithRequestMatchHandler(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+								w.WriteHeader(http.StatusNoContent)
+							}),
+						),
+					)),
+				}
+			},
+			expected: []*PackageVersion{
+				{PackageName: "mypackage", Version: "4.0.0", ID: 4},
+				{PackageName: "mypackage", Version: "5.0.0", ID: 5},
+			},
+			rules: map[int64]Rule{
+				0: RuleMaxVersions,
+				1: RuleMaxVersions,
+				2: RuleMaxVersions,
+				3: RuleMaxVersions,
+				4: RuleAge,
+				5: RuleAge,
+			},
+		},
+		{
+			name: "Image indexes are capped together with their manifests",
+			RetentionManager: func() *RetentionManager {
+				return &RetentionManager{
+					PackageNames:     []string{"mypackage"},
+					PackageType:      "container",
+					OrganizationName: "myorg",
+					Age:              time.Minute,
+					Untagged:         UntaggedDelete,
+					MaxVersions:      2,
+					ContainerRegistryTransport: registryTransport{
+						"HEAD /v2/myorg/mypackage/manifests/pr-1": headResponse(types.OCIImageIndex, digestOf(index)),
+						"GET /v2/myorg/mypackage/manifests/pr-1":  func() *http.Response { return manifestResponse(types.OCIImageIndex, index) },
+					},
+					GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+						mock.WithRequestMatch(
+							mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+							[]*github.PackageVersion{
+								agedVersion(1, digestOf(index), time.Hour, "pr-1"),
+								agedVersion(2, child1, 3*time.Hour),
+								agedVersion(3, child2, 3*time.Hour),
+								agedVersion(4, orphan, 2*time.Hour),
+							},
+						),
+						mock.WithRequestMatch(
+							mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+							nil,
+						),
+					)),
+				}
+			},
+			expected: []*PackageVersion{
+				{PackageName: "mypackage", Version: orphan, ID: 4},
+			},
+			rules: map[int64]Rule{
+				1: RuleMaxVersions,
+				2: RuleMaxVersions,
+				3: RuleMaxVersions,
+				4: RuleUntagged,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := test.RetentionManager()
+			a.Logger = logr.Discard()
+
+			removed, err := a.Run(context.TODO())
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, removed)
+
+			rules := make(map[int64]Rule)
+			for _, evaluation := range a.Evaluations() {
+				rules[evaluation.ID] = evaluation.Rule
+			}
+
+			assert.Equal(t, test.rules, rules)
+		})
+	}
+}
+
+func TestRunMaxVersionsConcurrently(t *testing.T) {
+	// The versions of all packages are interleaved by age, package-0 holds the oldest version of every round.
+	versions := make(map[string][]*github.PackageVersion)
+	for i := 0; i < 3; i++ {
+		for p := 0; p < 3; p++ {
+			packageName := fmt.Sprintf("package-%d", p)
+			id := int64(p*10 + i)
+			versions[packageName] = append(versions[packageName], &github.PackageVersion{
+				Name:      github.String(fmt.Sprintf("%d.0.0", i)),
+				ID:        github.Int64(id),
+				UpdatedAt: &github.Timestamp{Time: time.Now().Add(-time.Duration(100-i*3-p) * time.Hour)},
+			})
+		}
+	}
+
+	a := &RetentionManager{
+		PackageNames:     []string{"package-0", "package-1", "package-2"},
+		PackageType:      "maven",
+		OrganizationName: "myorg",
+		Age:              time.Minute,
+		MaxVersions:      4,
+		Concurrency:      3,
+		Logger:           logr.Discard(),
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					packageName := strings.Split(r.URL.Path, "/")[5]
+					_, _ = w.Write(mock.MustMarshal(versions[packageName]))
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			),
+		)),
+	}
+
+	removed, err := a.Run(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []*PackageVersion{
+		{PackageName: "package-0", Version: "0.0.0", ID: 0},
+		{PackageName: "package-0", Version: "1.0.0", ID: 1},
+		{PackageName: "package-1", Version: "0.0.0", ID: 10},
+		{PackageName: "package-2", Version: "0.0.0", ID: 20},
+	}, removed)
+
+	kept := 0
+	for _, evaluation := range a.Evaluations() {
+		if evaluation.Rule == RuleMaxVersions {
+			kept++
+		}
+	}
+
+	assert.Equal(t, 5, kept)
+}
+
+func TestRepository(t *testing.T) {
+	a := &RetentionManager{owner: "myorg"}
+	assert.Equal(t, "ghcr.io/myorg/charts/mychart", a.repository("charts/mychart"))
+
+	a.RegistryHost = "containers.github.example.com"
+	assert.Equal(t, "containers.github.example.com/myorg/charts/mychart", a.repository("charts/mychart"))
+}
+
+type rotatingTokenSource struct {
+	tokens []string
+}
+
+func (s *rotatingTokenSource) Token() (*oauth2.Token, error) {
+	token := s.tokens[0]
+	s.tokens = s.tokens[1:]
+	return &oauth2.Token{AccessToken: token}, nil
+}
+
+func TestRegistryAuth(t *testing.T) {
+	a := &RetentionManager{Token: "static"}
+	auth, err := a.registryAuth().Authorization()
+	assert.NoError(t, err)
+	assert.Equal(t, &authn.AuthConfig{Username: "ghcr", Password: "static"}, auth)
+
+	a.TokenSource = &rotatingTokenSource{tokens: []string{"token-1", "token-2"}}
+	for _, expected := range []string{"token-1", "token-2"} {
+		auth, err := a.registryAuth().Authorization()
+		assert.NoError(t, err)
+		assert.Equal(t, &authn.AuthConfig{Username: "ghcr", Password: expected}, auth)
+	}
+}
+
+type mockTransport struct {
+	responsePool []*http.Response
+}
+
+func newMockTransport(r ...*http.Response) http.RoundTripper {
+	return &mockTransport{
+		responsePool: r,
+	}
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	v := t.responsePool[0]
+	t.responsePool = t.responsePool[1:]
+	return v, nil
+}
+
+func noIndexResponseTransport() http.RoundTripper {
+	manifest := `{
 		"mediaType": "application/vnd.oci.image.manifest.v1+json",
 		"schemaVersion": 2,
 		"config": {
@@ -440,3 +1996,115 @@ func noIndexResponseTransport() http.RoundTripper {
 
 	return newMockTransport(&http.Response{StatusCode: http.StatusOK}, response, &http.Response{StatusCode: http.StatusOK}, response, &http.Response{StatusCode: http.StatusOK}, response)
 }
+
+func indexResponseTransport(mediaType types.MediaType, digests ...string) http.RoundTripper {
+	return newMockTransport(indexResponses(mediaType, digests...)...)
+}
+
+// indexResponses returns the registry responses for resolving an image index which references the given digests.
+func indexResponses(mediaType types.MediaType, digests ...string) []*http.Response {
+	childMediaType := types.OCIManifestSchema1
+	if mediaType == types.DockerManifestList {
+		childMediaType = types.DockerManifestSchema2
+	}
+
+	var children []v1.Descriptor
+	for _, digest := range digests {
+		children = append(children, descriptor(childMediaType, digest))
+	}
+
+	return tagResponses(mediaType, indexManifest(mediaType, children...))
+}
+
+// tagResponses returns the registry responses for resolving a tag which points to the given index manifest.
+func tagResponses(mediaType types.MediaType, manifest []byte) []*http.Response {
+	head := &http.Response{
+		Header:     make(http.Header),
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}
+	head.Header.Set("Content-Type", string(mediaType))
+	head.Header.Set("Content-Length", fmt.Sprintf("%d", len(manifest)))
+	head.Header.Set("Docker-Content-Digest", "sha256:a60d0af675b0bad03ebdb529ed1b6009604063136f30516568028008c221e62d")
+
+	return []*http.Response{{StatusCode: http.StatusOK}, head, {StatusCode: http.StatusOK}, manifestResponse(mediaType, manifest)}
+}
+
+func manifestResponse(mediaType types.MediaType, manifest []byte) *http.Response {
+	response := &http.Response{
+		Header:     make(http.Header),
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(manifest)),
+	}
+	response.Header.Set("Content-Type", string(mediaType))
+
+	return response
+}
+
+func indexManifest(mediaType types.MediaType, children ...v1.Descriptor) []byte {
+	manifest, err := json.Marshal(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaType,
+		Manifests:     children,
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return manifest
+}
+
+func descriptor(mediaType types.MediaType, digest string) v1.Descriptor {
+	return v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    v1.Hash{Algorithm: "sha256", Hex: strings.TrimPrefix(digest, "sha256:")},
+		Size:      1055,
+	}
+}
+
+func digestOf(manifest []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+}
+
+// registryTransport routes registry requests by method and path, unknown routes result in a 404.
+type registryTransport map[string]func() *http.Response
+
+func (t registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/v2/" {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	if route, ok := t[req.Method+" "+req.URL.Path]; ok {
+		return route(), nil
+	}
+
+	return &http.Response{StatusCode: http.StatusNotFound, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func headResponse(mediaType types.MediaType, digest string) func() *http.Response {
+	return func() *http.Response {
+		response := &http.Response{
+			Header:     make(http.Header),
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("")),
+		}
+		response.Header.Set("Content-Type", string(mediaType))
+		response.Header.Set("Content-Length", "1055")
+		response.Header.Set("Docker-Content-Digest", digest)
+
+		return response
+	}
+}
+
+func referrersResponse(digests ...string) func() *http.Response {
+	var children []v1.Descriptor
+	for _, digest := range digests {
+		children = append(children, descriptor(types.OCIManifestSchema1, digest))
+	}
+
+	manifest := indexManifest(types.OCIImageIndex, children...)
+	return func() *http.Response {
+		return manifestResponse(types.OCIImageIndex, manifest)
+	}
+}
@@ -0,0 +1,171 @@
+package ghpackage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-github/v53/github"
+)
+
+const (
+	// minBackoff is the wait time before the first retry of a transient failure.
+	minBackoff = time.Second
+	// maxBackoff caps the exponential backoff between retries.
+	maxBackoff = time.Minute
+)
+
+// sleep waits for the given duration or until the context is done.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitPause pauses all workers of a run until a rate limit is over.
+type rateLimitPause struct {
+	mu       sync.Mutex
+	resumeAt time.Time
+}
+
+// extend pauses all requests for at least the given duration.
+func (p *rateLimitPause) extend(d time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if resumeAt := time.Now().Add(d); resumeAt.After(p.resumeAt) {
+		p.resumeAt = resumeAt
+	}
+}
+
+// wait blocks until the pause is over or the context is done.
+func (p *rateLimitPause) wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	d := time.Until(p.resumeAt)
+	p.mu.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+
+	return sleep(ctx, d)
+}
+
+// retry calls fn until it succeeds, fails permanently or MaxRetries is exhausted.
+// Rate limit errors wait until the rate limit resets (or as long as requested by Retry-After),
+// transient server and network errors are retried with an exponential backoff.
+// A rate limit pauses the requests of all workers, not only the one which hit it.
+// fn must be idempotent.
+func (a *RetentionManager) retry(ctx context.Context, operation string, fn func() (*github.Response, error)) error {
+	for attempt := 0; ; attempt++ {
+		if err := a.pause.wait(ctx); err != nil {
+			return err
+		}
+
+		resp, err := fn()
+		if err == nil {
+			if resp != nil && resp.Rate.Limit > 0 {
+				a.Logger.V(1).Info("github api rate limit", "operation", operation, "remaining", resp.Rate.Remaining, "limit", resp.Rate.Limit, "reset", resp.Rate.Reset.Time)
+			}
+
+			return nil
+		}
+
+		wait, ok := retryAfter(err, attempt)
+		if !ok || attempt >= a.MaxRetries {
+			return err
+		}
+
+		a.Logger.Info("retrying failed request", "operation", operation, "attempt", attempt+1, "wait", wait.String(), "err", err)
+		if isRateLimit(err) && a.pause != nil {
+			a.pause.extend(wait)
+			continue
+		}
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// retryAfter returns how long to wait before retrying the failed request.
+// It returns false if the error is not worth retrying.
+func retryAfter(err error, attempt int) (time.Duration, bool) {
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		wait := time.Until(rateLimitErr.Rate.Reset.Time)
+		if wait < minBackoff {
+			wait = minBackoff
+		}
+
+		return wait, true
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+
+		return backoff(attempt), true
+	}
+
+	var errorResponse *github.ErrorResponse
+	if errors.As(err, &errorResponse) && errorResponse.Response != nil {
+		return backoff(attempt), isTransientStatus(errorResponse.Response.StatusCode)
+	}
+
+	var registryErr *transport.Error
+	if errors.As(err, &registryErr) {
+		return backoff(attempt), isTransientStatus(registryErr.StatusCode)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return backoff(attempt), true
+	}
+
+	return 0, false
+}
+
+func isRateLimit(err error) bool {
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	return errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr)
+}
+
+// backoff returns the exponential backoff for the given attempt.
+func backoff(attempt int) time.Duration {
+	wait := minBackoff
+	for i := 0; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	return wait
+}
+
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
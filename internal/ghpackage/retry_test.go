@@ -0,0 +1,370 @@
+package ghpackage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-github/v53/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	secondaryRetryAfter := 30 * time.Second
+
+	var tests = []struct {
+		name      string
+		err       error
+		attempt   int
+		expected  time.Duration
+		retryable bool
+	}{
+		{
+			name: "Rate limit errors wait until the rate limit resets",
+			err: &github.RateLimitError{
+				Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}},
+			},
+			expected:  time.Hour,
+			retryable: true,
+		},
+		{
+			name: "Rate limit errors with a reset in the past wait the minimum backoff",
+			err: &github.RateLimitError{
+				Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(-time.Hour)}},
+			},
+			expected:  minBackoff,
+			retryable: true,
+		},
+		{
+			name:      "Secondary rate limit errors wait as long as requested by Retry-After",
+			err:       &github.AbuseRateLimitError{RetryAfter: &secondaryRetryAfter},
+			expected:  secondaryRetryAfter,
+			retryable: true,
+		},
+		{
+			name:      "Secondary rate limit errors without Retry-After use the backoff",
+			err:       &github.AbuseRateLimitError{},
+			attempt:   2,
+			expected:  4 * time.Second,
+			retryable: true,
+		},
+		{
+			name:      "Server errors are retried with an exponential backoff",
+			err:       &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}},
+			attempt:   3,
+			expected:  8 * time.Second,
+			retryable: true,
+		},
+		{
+			name:      "The backoff is capped",
+			err:       &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}},
+			attempt:   20,
+			expected:  maxBackoff,
+			retryable: true,
+		},
+		{
+			name: "Client errors are not retried",
+			err:  &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}},
+		},
+		{
+			name:      "Transient registry errors are retried",
+			err:       fmt.Errorf("head: %w", &transport.Error{StatusCode: http.StatusTooManyRequests}),
+			expected:  minBackoff,
+			retryable: true,
+		},
+		{
+			name: "Registry client errors are not retried",
+			err:  &transport.Error{StatusCode: http.StatusUnauthorized},
+		},
+		{
+			name: "Unknown errors are not retried",
+			err:  errors.New("unknown"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wait, ok := retryAfter(test.err, test.attempt)
+			assert.Equal(t, test.retryable, ok)
+			if test.retryable {
+				assert.InDelta(t, test.expected, wait, float64(time.Second))
+			}
+		})
+	}
+}
+
+func TestRunRetriesTransientFailures(t *testing.T) {
+	var (
+		waits []time.Duration
+		calls = make(map[string]int)
+	)
+
+	defer func(s func(context.Context, time.Duration) error) {
+		sleep = s
+	}(sleep)
+
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	// failOnce fails the first request of every route with the given status code.
+	failOnce := func(route string, code int, next func(w http.ResponseWriter)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			calls[route]++
+			if calls[route] == 1 {
+				mock.WriteError(w, code, "unavailable")
+				return
+			}
+
+			next(w)
+		}
+	}
+
+	var (
+		packageName1       = "sha256:a60d0af675b0bad03ebdb529ed1b6009604063136f30516568028008c221e62d"
+		packageID1   int64 = 1
+		headCalls          = 0
+	)
+
+	a := &RetentionManager{
+		PackageNames:     []string{"mypackage"},
+		PackageType:      "container",
+		OrganizationName: "myorg",
+		VersionMatch:     regexp.MustCompile(`^v1`),
+		Age:              time.Second * 10,
+		MaxRetries:       3,
+		Logger:           logr.Discard(),
+		ContainerRegistryTransport: registryTransport{
+			"HEAD /v2/myorg/mypackage/manifests/v1.0.0": func() *http.Response {
+				headCalls++
+				if headCalls == 1 {
+					return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: make(http.Header), Body: http.NoBody}
+				}
+
+				return headResponse(types.OCIManifestSchema1, packageName1)()
+			},
+		},
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatchHandler(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				failOnce("list", http.StatusBadGateway, func(w http.ResponseWriter) {
+					_, _ = w.Write(mock.MustMarshal([]*github.PackageVersion{
+						{
+							Name:      &packageName1,
+							ID:        &packageID1,
+							UpdatedAt: &github.Timestamp{Time: time.Now().Add(-60 * time.Second)},
+							Metadata: &github.PackageMetadata{
+								Container: &github.PackageContainerMetadata{
+									Tags: []string{"v1.0.0"},
+								},
+							},
+						},
+					}))
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				failOnce("delete", http.StatusInternalServerError, func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			),
+		)),
+	}
+
+	removed, err := a.Run(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []*PackageVersion{
+		{
+			PackageName: "mypackage",
+			Version:     packageName1,
+			ID:          packageID1,
+		},
+	}, removed)
+	assert.Equal(t, 2, calls["list"])
+	assert.Equal(t, 2, calls["delete"])
+	assert.Equal(t, 2, headCalls)
+	assert.Equal(t, []time.Duration{minBackoff, minBackoff, minBackoff}, waits)
+}
+
+func TestRunRetriedDeletionNotFound(t *testing.T) {
+	defer func(s func(context.Context, time.Duration) error) {
+		sleep = s
+	}(sleep)
+
+	sleep = func(ctx context.Context, d time.Duration) error {
+		return nil
+	}
+
+	var (
+		packageName1       = "1.0.0"
+		packageID1   int64 = 1
+		calls              = 0
+	)
+
+	a := &RetentionManager{
+		PackageNames:     []string{"mypackage"},
+		PackageType:      "maven",
+		OrganizationName: "myorg",
+		Age:              time.Minute,
+		MaxRetries:       3,
+		Logger:           logr.Discard(),
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				[]*github.PackageVersion{
+					{
+						Name:      &packageName1,
+						ID:        &packageID1,
+						UpdatedAt: &github.Timestamp{Time: time.Now().Add(-time.Hour)},
+					},
+				},
+			),
+			mock.WithRequestMatchHandler(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					calls++
+
+					// The first deletion is applied but its response is lost
+					if calls == 1 {
+						mock.WriteError(w, http.StatusBadGateway, "bad gateway")
+						return
+					}
+
+					mock.WriteError(w, http.StatusNotFound, "not found")
+				}),
+			),
+		)),
+	}
+
+	removed, err := a.Run(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, []*PackageVersion{
+		{PackageName: "mypackage", Version: packageName1, ID: packageID1},
+	}, removed)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryGivesUpAfterMaxRetries(t *testing.T) {
+	var waits []time.Duration
+
+	defer func(s func(context.Context, time.Duration) error) {
+		sleep = s
+	}(sleep)
+
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	a := &RetentionManager{
+		MaxRetries: 2,
+		Logger:     logr.Discard(),
+	}
+
+	calls := 0
+	err := a.retry(context.TODO(), "test", func() (*github.Response, error) {
+		calls++
+		return nil, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []time.Duration{minBackoff, 2 * minBackoff}, waits)
+}
+
+func TestRunPausesAllWorkersOnRateLimit(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		waits    []time.Duration
+		calls    int
+		paused   = make(chan struct{})
+		pauseOne sync.Once
+	)
+
+	defer func(s func(context.Context, time.Duration) error) {
+		sleep = s
+	}(sleep)
+
+	sleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		waits = append(waits, d)
+		pauseOne.Do(func() { close(paused) })
+		return nil
+	}
+
+	var versions []*github.PackageVersion
+	for i := 1; i <= 3; i++ {
+		versions = append(versions, &github.PackageVersion{
+			Name:      github.String(fmt.Sprintf("%d.0.0", i)),
+			ID:        github.Int64(int64(i)),
+			UpdatedAt: &github.Timestamp{Time: time.Now().Add(-time.Duration(i) * time.Hour)},
+		})
+	}
+
+	a := &RetentionManager{
+		PackageNames:     []string{"mypackage"},
+		PackageType:      "maven",
+		OrganizationName: "myorg",
+		Age:              time.Minute,
+		Concurrency:      2,
+		MaxRetries:       3,
+		Logger:           logr.Discard(),
+		GithubClient: github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(
+				mock.GetOrgsPackagesVersionsByOrgByPackageTypeByPackageName,
+				versions,
+			),
+			mock.WithRequestMatchHandler(
+				mock.DeleteOrgsPackagesVersionsByOrgByPackageTypeByPackageNameByPackageVersionId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					calls++
+					first := calls == 1
+					mu.Unlock()
+
+					// The first request hits the secondary rate limit. It is sent without a Retry-After header,
+					// otherwise the github client would refuse further requests by itself.
+					if first {
+						w.WriteHeader(http.StatusForbidden)
+						_, _ = w.Write(mock.MustMarshal(github.ErrorResponse{
+							Message:          "You have exceeded a secondary rate limit",
+							DocumentationURL: "https://docs.github.com/rest/overview/resources-in-the-rest-api#secondary-rate-limits",
+						}))
+						return
+					}
+
+					// Requests which are already in flight are answered once the rate limit is known
+					select {
+					case <-paused:
+					case <-time.After(5 * time.Second):
+					}
+
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			),
+		)),
+	}
+
+	removed, err := a.Run(context.TODO())
+	assert.NoError(t, err)
+	assert.Len(t, removed, 3)
+	assert.Equal(t, 4, calls)
+
+	// The worker which hit the rate limit and the worker which deletes the next version both wait
+	assert.GreaterOrEqual(t, len(waits), 2)
+	for _, wait := range waits {
+		assert.InDelta(t, float64(minBackoff), float64(wait), float64(100*time.Millisecond))
+	}
+}
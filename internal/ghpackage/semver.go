@@ -0,0 +1,93 @@
+package ghpackage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-github/v53/github"
+)
+
+// SemverPolicy keeps the newest releases per major/minor group.
+// Pre-releases are grouped separately and use their own keep count.
+type SemverPolicy struct {
+	Keep           int
+	KeepPrerelease int
+}
+
+type semverVersion struct {
+	version *github.PackageVersion
+	semver  *semver.Version
+}
+
+// keep returns the IDs of all versions which are protected by the policy.
+// Versions which can not be parsed as semver are always protected, except cosign companion artifacts.
+func (p *SemverPolicy) keep(packageType string, versions []*github.PackageVersion) map[int64]struct{} {
+	keep := make(map[int64]struct{})
+	groups := make(map[string][]semverVersion)
+
+	for _, version := range versions {
+		// Untagged container versions are manifests referenced by an index and do not carry a version.
+		if packageType == "container" && len(tagsOf(version)) == 0 {
+			continue
+		}
+
+		// Signatures, attestations and SBOMs are no releases of their own
+		if _, ok := companionSubject(version); ok {
+			continue
+		}
+
+		v := parseSemver(packageType, version)
+		if v == nil {
+			keep[*version.ID] = struct{}{}
+			continue
+		}
+
+		group := fmt.Sprintf("%d.%d", v.Major(), v.Minor())
+		if v.Prerelease() != "" {
+			group += "-prerelease"
+		}
+
+		groups[group] = append(groups[group], semverVersion{version: version, semver: v})
+	}
+
+	for group, members := range groups {
+		limit := p.Keep
+		if strings.HasSuffix(group, "-prerelease") {
+			limit = p.KeepPrerelease
+		}
+
+		sort.SliceStable(members, func(i, j int) bool {
+			return members[i].semver.GreaterThan(members[j].semver)
+		})
+
+		for i := 0; i < limit && i < len(members); i++ {
+			keep[*members[i].version.ID] = struct{}{}
+		}
+	}
+
+	return keep
+}
+
+// parseSemver returns the highest semver found in either the version name or, for containers, its tags.
+func parseSemver(packageType string, version *github.PackageVersion) *semver.Version {
+	candidates := []string{*version.Name}
+	if packageType == "container" {
+		candidates = tagsOf(version)
+	}
+
+	var highest *semver.Version
+	for _, candidate := range candidates {
+		v, err := semver.StrictNewVersion(strings.TrimPrefix(candidate, "v"))
+		if err != nil {
+			continue
+		}
+
+		if highest == nil || v.GreaterThan(highest) {
+			highest = v
+		}
+	}
+
+	return highest
+}
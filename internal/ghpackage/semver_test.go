@@ -0,0 +1,115 @@
+package ghpackage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v53/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemverPolicyKeep(t *testing.T) {
+	var tests = []struct {
+		name        string
+		policy      *SemverPolicy
+		packageType string
+		versions    []*github.PackageVersion
+		expected    []int64
+	}{
+		{
+			name:        "Newest patch releases per minor are kept",
+			policy:      &SemverPolicy{Keep: 2},
+			packageType: "npm",
+			versions: []*github.PackageVersion{
+				newVersion(1, "1.0.0"),
+				newVersion(2, "1.0.2"),
+				newVersion(3, "1.0.10"),
+				newVersion(4, "1.1.0"),
+				newVersion(5, "2.0.0"),
+				newVersion(6, "v2.0.1"),
+				newVersion(7, "2.0.2"),
+			},
+			expected: []int64{2, 3, 4, 6, 7},
+		},
+		{
+			name:        "Companion artifacts of containers are not kept",
+			policy:      &SemverPolicy{Keep: 1},
+			packageType: "container",
+			versions: []*github.PackageVersion{
+				newVersion(1, "sha256:1111", "v1.0.0"),
+				newVersion(2, "sha256:2222", "sha256-"+strings.Repeat("1", 64)+".sig"),
+				newVersion(3, "sha256:3333", "sha256-"+strings.Repeat("1", 64)+".att"),
+				newVersion(4, "sha256:4444", "sha256-"+strings.Repeat("1", 64)),
+			},
+			expected: []int64{1},
+		},
+		{
+			name:        "Versions which do not parse are kept",
+			policy:      &SemverPolicy{Keep: 1},
+			packageType: "maven",
+			versions: []*github.PackageVersion{
+				newVersion(1, "1.0.0"),
+				newVersion(2, "1.0.1"),
+				newVersion(3, "1.0.1-SNAPSHOT-foo-bar_"),
+				newVersion(4, "latest"),
+			},
+			expected: []int64{2, 3, 4},
+		},
+		{
+			name:        "Pre-releases have their own keep count",
+			policy:      &SemverPolicy{Keep: 1, KeepPrerelease: 2},
+			packageType: "npm",
+			versions: []*github.PackageVersion{
+				newVersion(1, "0.0.0-1"),
+				newVersion(2, "0.0.0-2"),
+				newVersion(3, "0.0.0-3"),
+				newVersion(4, "0.0.1"),
+				newVersion(5, "0.0.2"),
+			},
+			expected: []int64{2, 3, 5},
+		},
+		{
+			name:        "Container tags are used and untagged versions are ignored",
+			policy:      &SemverPolicy{Keep: 1},
+			packageType: "container",
+			versions: []*github.PackageVersion{
+				newVersion(1, "sha256:1", "1.0.0"),
+				newVersion(2, "sha256:2", "1.0.1", "latest"),
+				newVersion(3, "sha256:3", "main"),
+				newVersion(4, "sha256:4"),
+			},
+			expected: []int64{2, 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var kept []int64
+			keep := test.policy.keep(test.packageType, test.versions)
+			for _, version := range test.versions {
+				if _, ok := keep[*version.ID]; ok {
+					kept = append(kept, *version.ID)
+				}
+			}
+
+			assert.Equal(t, test.expected, kept)
+		})
+	}
+}
+
+func newVersion(id int64, name string, tags ...string) *github.PackageVersion {
+	version := &github.PackageVersion{
+		ID:   &id,
+		Name: &name,
+	}
+
+	if len(tags) > 0 {
+		version.Metadata = &github.PackageMetadata{
+			Container: &github.PackageContainerMetadata{
+				Tags: tags,
+			},
+		}
+	}
+
+	return version
+}
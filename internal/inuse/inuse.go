@@ -0,0 +1,202 @@
+// Package inuse finds container image references in Kubernetes manifests, Helm values,
+// Kustomize overlays and Dockerfiles.
+package inuse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"gopkg.in/yaml.v3"
+)
+
+// imagePattern matches image references with an explicit registry host, e.g. ghcr.io/org/app:v1@sha256:...
+var imagePattern = regexp.MustCompile(`(?:[a-z0-9-]+\.)+[a-z0-9-]+(?::[0-9]+)?(?:/[a-z0-9._-]+)+(?::[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(?:@sha256:[a-f0-9]{64})?`)
+
+// FromPaths scans the given files and directories for image references.
+// Directories are walked recursively and only YAML files and Dockerfiles are scanned, hidden directories are skipped.
+// References are collected conservatively, an image which might be in use is rather reported than missed.
+func FromPaths(paths ...string) ([]name.Reference, error) {
+	s := &scanner{
+		seen: make(map[string]struct{}),
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			if err := s.scanFile(path); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		root := path
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				if path != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if !isManifest(d.Name()) && !isDockerfile(d.Name()) {
+				return nil
+			}
+
+			return s.scanFile(path)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.references, nil
+}
+
+type scanner struct {
+	references []name.Reference
+	seen       map[string]struct{}
+}
+
+func (s *scanner) scanFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Image references are found in plain text as well, this covers Dockerfiles and templated
+	// manifests like Helm charts which are no valid YAML.
+	lines := bufio.NewScanner(bytes.NewReader(b))
+	lines.Buffer(make([]byte, 0, 64*1024), len(b)+1)
+	for lines.Scan() {
+		for _, match := range imagePattern.FindAllString(lines.Text(), -1) {
+			s.add(match)
+		}
+	}
+
+	if err := lines.Err(); err != nil {
+		return err
+	}
+
+	if !isManifest(path) {
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		// Templates are no valid YAML, their references have been collected from the plain text
+		if err != nil {
+			return nil
+		}
+
+		s.walk(&document)
+	}
+}
+
+// walk collects image references which are split across multiple fields.
+// This covers Helm values (registry, repository, tag) and Kustomize images (name, newName, newTag, digest).
+func (s *scanner) walk(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		fields := make(map[string]string)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].Kind == yaml.ScalarNode {
+				fields[node.Content[i].Value] = node.Content[i+1].Value
+			}
+		}
+
+		if ref := imageFromFields(fields); ref != "" {
+			s.add(ref)
+		}
+	}
+
+	for _, child := range node.Content {
+		s.walk(child)
+	}
+}
+
+func imageFromFields(fields map[string]string) string {
+	tag := first(fields, "newTag", "tag")
+	digest := fields["digest"]
+	if tag == "" && digest == "" {
+		return ""
+	}
+
+	repository := first(fields, "newName", "repository", "image", "name")
+	if repository == "" {
+		return ""
+	}
+
+	if registry := fields["registry"]; registry != "" {
+		repository = registry + "/" + repository
+	}
+
+	ref := repository
+	if tag != "" {
+		ref += ":" + tag
+	}
+
+	if digest != "" {
+		ref += "@" + digest
+	}
+
+	return ref
+}
+
+func first(fields map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if fields[key] != "" {
+			return fields[key]
+		}
+	}
+
+	return ""
+}
+
+func (s *scanner) add(reference string) {
+	ref, err := name.ParseReference(reference)
+	if err != nil {
+		return
+	}
+
+	if _, ok := s.seen[ref.String()]; ok {
+		return
+	}
+
+	s.seen[ref.String()] = struct{}{}
+	s.references = append(s.references, ref)
+}
+
+func isManifest(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func isDockerfile(fileName string) bool {
+	fileName = strings.ToLower(fileName)
+	return fileName == "dockerfile" || fileName == "containerfile" ||
+		strings.HasPrefix(fileName, "dockerfile.") || strings.HasSuffix(fileName, ".dockerfile")
+}
@@ -0,0 +1,148 @@
+package inuse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromPaths(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	var tests = []struct {
+		name     string
+		files    map[string]string
+		expected []string
+	}{
+		{
+			name: "Kubernetes manifests",
+			files: map[string]string{
+				"deploy/deployment.yaml": `
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      initContainers:
+      - image: ghcr.io/myorg/init:v1.0.0@` + digest + `
+      containers:
+      - name: app
+        image: "ghcr.io/myorg/app:v1.2.3"
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - image: ghcr.io/myorg/job
+`,
+			},
+			expected: []string{
+				"ghcr.io/myorg/init:v1.0.0@" + digest,
+				"ghcr.io/myorg/app:v1.2.3",
+				"ghcr.io/myorg/job",
+			},
+		},
+		{
+			name: "Helm values and templates",
+			files: map[string]string{
+				"chart/values.yaml": `
+image:
+  registry: ghcr.io
+  repository: myorg/app
+  tag: v2.0.0
+`,
+				"chart/templates/deployment.yaml": `
+spec:
+  containers:
+  - image: {{ .Values.image.registry }}/{{ .Values.image.repository }}:{{ .Values.image.tag }}
+  - image: ghcr.io/myorg/sidecar:{{ .Chart.AppVersion }}
+  - image: ghcr.io/myorg/proxy:v1.0.0
+`,
+			},
+			expected: []string{
+				"ghcr.io/myorg/sidecar",
+				"ghcr.io/myorg/proxy:v1.0.0",
+				"ghcr.io/myorg/app:v2.0.0",
+			},
+		},
+		{
+			name: "Kustomize images",
+			files: map[string]string{
+				"overlays/prod/kustomization.yaml": `
+images:
+- name: app
+  newName: ghcr.io/myorg/app
+  newTag: v3.0.0
+- name: ghcr.io/myorg/worker
+  digest: ` + digest + `
+`,
+			},
+			expected: []string{
+				"ghcr.io/myorg/app",
+				"ghcr.io/myorg/worker",
+				"ghcr.io/myorg/worker@" + digest,
+				"ghcr.io/myorg/app:v3.0.0",
+			},
+		},
+		{
+			name: "Dockerfiles",
+			files: map[string]string{
+				"Dockerfile": `
+FROM ghcr.io/myorg/base:v1.0.0 AS build
+COPY --from=ghcr.io/myorg/tools:v2.0.0 /bin/tool /bin/tool
+FROM alpine:3.20
+`,
+				"build/app.Dockerfile": "FROM ghcr.io/myorg/runtime@" + digest,
+				"README.md":            "ghcr.io/myorg/ignored:v1.0.0",
+				".git/config.yaml":     "image: ghcr.io/myorg/ignored:v1.0.0",
+			},
+			expected: []string{
+				"ghcr.io/myorg/base:v1.0.0",
+				"ghcr.io/myorg/tools:v2.0.0",
+				"ghcr.io/myorg/runtime@" + digest,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for path, content := range test.files {
+				path = filepath.Join(dir, path)
+				assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+			}
+
+			refs, err := FromPaths(dir)
+			assert.NoError(t, err)
+
+			var found []string
+			for _, ref := range refs {
+				found = append(found, ref.String())
+			}
+
+			assert.ElementsMatch(t, test.expected, found)
+		})
+	}
+}
+
+func TestFromPathsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "images.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("ghcr.io/myorg/app:v1.0.0\n"), 0o644))
+
+	refs, err := FromPaths(path)
+	assert.NoError(t, err)
+	if assert.Len(t, refs, 1) {
+		assert.Equal(t, "ghcr.io/myorg/app:v1.0.0", refs[0].String())
+	}
+
+	_, err = FromPaths(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+// Package kube finds the container images used by the workloads of a Kubernetes cluster.
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NamespaceSelector selects the namespaces which are queried.
+// If neither names nor a label selector are given all namespaces are queried.
+type NamespaceSelector struct {
+	Names         []string
+	LabelSelector string
+}
+
+// NewClient creates a client from a kubeconfig file.
+func NewClient(kubeconfig string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %s: %w", kubeconfig, err)
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// Images returns the image references of all Pods, Deployments, StatefulSets, DaemonSets and CronJobs in the selected namespaces.
+// For pods the image IDs reported by the container runtime are included, these pin the digest of a possibly moved tag.
+func Images(ctx context.Context, client kubernetes.Interface, selector NamespaceSelector) ([]name.Reference, error) {
+	namespaces, err := selector.namespaces(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &collector{
+		seen: make(map[string]struct{}),
+	}
+
+	for _, namespace := range namespaces {
+		if err := c.collect(ctx, client, namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.references, nil
+}
+
+func (s NamespaceSelector) namespaces(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	if s.LabelSelector == "" {
+		if len(s.Names) == 0 {
+			return []string{metav1.NamespaceAll}, nil
+		}
+
+		return s.Names, nil
+	}
+
+	list, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: s.LabelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	var namespaces []string
+	for _, namespace := range list.Items {
+		if len(s.Names) > 0 && !contains(s.Names, namespace.Name) {
+			continue
+		}
+
+		namespaces = append(namespaces, namespace.Name)
+	}
+
+	return namespaces, nil
+}
+
+type collector struct {
+	references []name.Reference
+	seen       map[string]struct{}
+}
+
+func (c *collector) collect(ctx context.Context, client kubernetes.Interface, namespace string) error {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	for _, pod := range pods.Items {
+		c.addPodSpec(pod.Spec)
+
+		for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+			for _, status := range statuses {
+				c.add(status.Image)
+				c.add(imageID(status.ImageID))
+			}
+		}
+	}
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	for _, deployment := range deployments.Items {
+		c.addPodSpec(deployment.Spec.Template.Spec)
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+
+	for _, statefulSet := range statefulSets.Items {
+		c.addPodSpec(statefulSet.Spec.Template.Spec)
+	}
+
+	daemonSets, err := client.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list daemonsets: %w", err)
+	}
+
+	for _, daemonSet := range daemonSets.Items {
+		c.addPodSpec(daemonSet.Spec.Template.Spec)
+	}
+
+	cronJobs, err := client.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list cronjobs: %w", err)
+	}
+
+	for _, cronJob := range cronJobs.Items {
+		c.addPodSpec(cronJob.Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	return nil
+}
+
+func (c *collector) addPodSpec(spec corev1.PodSpec) {
+	for _, container := range spec.InitContainers {
+		c.add(container.Image)
+	}
+
+	for _, container := range spec.Containers {
+		c.add(container.Image)
+	}
+
+	for _, container := range spec.EphemeralContainers {
+		c.add(container.Image)
+	}
+}
+
+func (c *collector) add(image string) {
+	if image == "" {
+		return
+	}
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return
+	}
+
+	if _, ok := c.seen[ref.String()]; ok {
+		return
+	}
+
+	c.seen[ref.String()] = struct{}{}
+	c.references = append(c.references, ref)
+}
+
+// imageID strips the runtime specific prefix of an image ID, e.g. docker-pullable://ghcr.io/org/app@sha256:...
+// Image IDs which are only a digest of the image config can not be resolved to a repository and are ignored.
+func imageID(id string) string {
+	if i := strings.Index(id, "://"); i != -1 {
+		id = id[i+3:]
+	}
+
+	if !strings.Contains(id, "/") {
+		return ""
+	}
+
+	return id
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
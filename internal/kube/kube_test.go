@@ -0,0 +1,116 @@
+package kube
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestImages(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	podSpec := func(images ...string) corev1.PodSpec {
+		var spec corev1.PodSpec
+		for _, image := range images {
+			spec.Containers = append(spec.Containers, corev1.Container{Image: image})
+		}
+
+		return spec
+	}
+
+	objects := []runtime.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "production", Labels: map[string]string{"env": "production"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging", Labels: map[string]string{"env": "staging"}}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "production"},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Image: "ghcr.io/myorg/init:v1.0.0"}},
+				Containers:     []corev1.Container{{Image: "ghcr.io/myorg/app:latest"}},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Image: "ghcr.io/myorg/app:latest", ImageID: "docker-pullable://ghcr.io/myorg/app@" + digest},
+					{Image: "ghcr.io/myorg/app:latest", ImageID: digest},
+				},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "production"},
+			Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: podSpec("ghcr.io/myorg/app:latest")}},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "production"},
+			Spec:       appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: podSpec("ghcr.io/myorg/db:v2.0.0")}},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "production"},
+			Spec:       appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: podSpec("ghcr.io/myorg/agent@" + digest)}},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "production"},
+			Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{Spec: podSpec("ghcr.io/myorg/backup:v3.0.0")},
+			}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "staging"},
+			Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: podSpec("ghcr.io/myorg/app:pr-1")}},
+		},
+	}
+
+	production := []string{
+		"ghcr.io/myorg/init:v1.0.0",
+		"ghcr.io/myorg/app:latest",
+		"ghcr.io/myorg/app@" + digest,
+		"ghcr.io/myorg/db:v2.0.0",
+		"ghcr.io/myorg/agent@" + digest,
+		"ghcr.io/myorg/backup:v3.0.0",
+	}
+
+	var tests = []struct {
+		name     string
+		selector NamespaceSelector
+		expected []string
+	}{
+		{
+			name:     "All namespaces are queried by default",
+			expected: append([]string{"ghcr.io/myorg/app:pr-1"}, production...),
+		},
+		{
+			name:     "Namespaces are selected by name",
+			selector: NamespaceSelector{Names: []string{"staging"}},
+			expected: []string{"ghcr.io/myorg/app:pr-1"},
+		},
+		{
+			name:     "Namespaces are selected by label",
+			selector: NamespaceSelector{LabelSelector: "env=production"},
+			expected: production,
+		},
+		{
+			name:     "Namespaces must match both names and labels",
+			selector: NamespaceSelector{Names: []string{"staging"}, LabelSelector: "env=production"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			refs, err := Images(context.TODO(), fake.NewClientset(objects...), test.selector)
+			assert.NoError(t, err)
+
+			var found []string
+			for _, ref := range refs {
+				found = append(found, ref.String())
+			}
+
+			assert.ElementsMatch(t, test.expected, found)
+		})
+	}
+}
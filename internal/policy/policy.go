@@ -0,0 +1,271 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy describes retention rules for multiple package owners.
+type Policy struct {
+	Defaults Rules   `yaml:"defaults"`
+	Owners   []Owner `yaml:"owners"`
+}
+
+// Owner selects either an organization or a user which owns packages.
+// If neither is set the packages of the authenticated user are selected.
+type Owner struct {
+	Organization string    `yaml:"organization"`
+	User         string    `yaml:"user"`
+	Defaults     Rules     `yaml:"defaults"`
+	Packages     []Package `yaml:"packages"`
+}
+
+// Package selects packages of a type either by name or, if no names are given, by discovering them.
+type Package struct {
+	Type       string   `yaml:"type"`
+	Names      []string `yaml:"names"`
+	Match      *Regexp  `yaml:"match"`
+	Repository string   `yaml:"repository"`
+	Rules      `yaml:",inline"`
+}
+
+// Rules is a set of retention rules. Unset rules are inherited from the defaults.
+type Rules struct {
+	Age                *Duration `yaml:"age"`
+	KeepLast           *int      `yaml:"keep-last"`
+	VersionMatch       *Regexp   `yaml:"version-match"`
+	ProtectMatch       *Regexp   `yaml:"protect-match"`
+	Untagged           *Untagged `yaml:"untagged"`
+	Semver             *Semver   `yaml:"semver"`
+	CompanionArtifacts *bool     `yaml:"companion-artifacts"`
+}
+
+// Semver configures the semver retention policy.
+type Semver struct {
+	Keep           int `yaml:"keep"`
+	KeepPrerelease int `yaml:"keep-prerelease"`
+}
+
+// Merge returns the rules with all unset rules taken from defaults.
+func (r Rules) Merge(defaults Rules) Rules {
+	if r.Age == nil {
+		r.Age = defaults.Age
+	}
+	if r.KeepLast == nil {
+		r.KeepLast = defaults.KeepLast
+	}
+	if r.VersionMatch == nil {
+		r.VersionMatch = defaults.VersionMatch
+	}
+	if r.ProtectMatch == nil {
+		r.ProtectMatch = defaults.ProtectMatch
+	}
+	if r.Untagged == nil {
+		r.Untagged = defaults.Untagged
+	}
+	if r.Semver == nil {
+		r.Semver = defaults.Semver
+	}
+	if r.CompanionArtifacts == nil {
+		r.CompanionArtifacts = defaults.CompanionArtifacts
+	}
+
+	return r
+}
+
+// Duration is a time.Duration which is parsed from a duration string such as 2160h.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q: %w", value.Line, s, err)
+	}
+
+	if duration < 0 {
+		return fmt.Errorf("line %d: duration %q must not be negative", value.Line, s)
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+// Regexp is a regular expression which is compiled while parsing.
+type Regexp struct {
+	*regexp.Regexp
+}
+
+func (r *Regexp) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid regex %q: %w", value.Line, s, err)
+	}
+
+	r.Regexp = re
+	return nil
+}
+
+// Untagged is the mode how untagged container versions are handled.
+type Untagged string
+
+func (u *Untagged) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	switch s {
+	case "keep", "delete", "delete-unreferenced":
+	default:
+		return fmt.Errorf("line %d: invalid untagged mode %q, must be one of 'keep', 'delete' or 'delete-unreferenced'", value.Line, s)
+	}
+
+	*u = Untagged(s)
+	return nil
+}
+
+// Load parses and strictly validates a policy.
+// Unknown fields and invalid values result in an error which contains the line of the offending value.
+func Load(r io.Reader) (*Policy, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+
+	if root.Kind == 0 {
+		return nil, errors.New("policy is empty")
+	}
+
+	policy := &Policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(policy); err != nil {
+		return nil, err
+	}
+
+	return policy, policy.validate(&root)
+}
+
+func (p *Policy) validate(root *yaml.Node) error {
+	if len(p.Owners) == 0 {
+		return fmt.Errorf("line %d: at least one owner must be given", lineOf(root))
+	}
+
+	if err := p.Defaults.validate(lookup(root, "defaults")); err != nil {
+		return err
+	}
+
+	for i, owner := range p.Owners {
+		ownerNode := lookup(root, "owners", i)
+		if owner.Organization != "" && owner.User != "" {
+			return fmt.Errorf("line %d: only one of organization and user can be given", lineOf(ownerNode))
+		}
+
+		if err := owner.Defaults.validate(lookup(ownerNode, "defaults")); err != nil {
+			return err
+		}
+
+		if len(owner.Packages) == 0 {
+			return fmt.Errorf("line %d: at least one package must be given", lineOf(ownerNode))
+		}
+
+		for j, pkg := range owner.Packages {
+			packageNode := lookup(ownerNode, "packages", j)
+			switch pkg.Type {
+			case "":
+				return fmt.Errorf("line %d: package type must be given", lineOf(packageNode))
+			case "container", "npm", "maven", "rubygems", "docker", "nuget":
+			default:
+				return fmt.Errorf("line %d: invalid package type %q, must be one of 'container', 'npm', 'maven', 'rubygems', 'docker' or 'nuget'", lineOf(lookup(packageNode, "type")), pkg.Type)
+			}
+
+			if len(pkg.Names) > 0 && (pkg.Match != nil || pkg.Repository != "") {
+				return fmt.Errorf("line %d: names can not be combined with match or repository", lineOf(packageNode))
+			}
+
+			if err := pkg.Rules.validate(packageNode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r Rules) validate(node *yaml.Node) error {
+	if r.KeepLast != nil && *r.KeepLast < 0 {
+		return fmt.Errorf("line %d: keep-last must not be negative", lineOf(lookup(node, "keep-last")))
+	}
+
+	if r.Semver != nil && (r.Semver.Keep < 1 || r.Semver.KeepPrerelease < 0) {
+		return fmt.Errorf("line %d: semver keep must be at least 1 and keep-prerelease must not be negative", lineOf(lookup(node, "semver")))
+	}
+
+	return nil
+}
+
+// lookup returns the node at the given path of mapping keys and sequence indexes.
+// If the path does not exist the deepest existing node is returned.
+func lookup(node *yaml.Node, path ...interface{}) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return lookup(node.Content[0], path...)
+	}
+
+	if len(path) == 0 {
+		return node
+	}
+
+	switch key := path[0].(type) {
+	case string:
+		if node.Kind != yaml.MappingNode {
+			return node
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return lookup(node.Content[i+1], path[1:]...)
+			}
+		}
+	case int:
+		if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+			return lookup(node.Content[key], path[1:]...)
+		}
+	}
+
+	return node
+}
+
+func lineOf(node *yaml.Node) int {
+	if node == nil {
+		return 0
+	}
+
+	return node.Line
+}
@@ -0,0 +1,176 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	var tests = []struct {
+		name          string
+		policy        string
+		expectedError string
+	}{
+		{
+			name: "Valid policy is loaded",
+			policy: `
+defaults:
+  age: 2160h
+  keep-last: 3
+owners:
+- organization: myorg
+  packages:
+  - type: container
+    names: [app]
+    version-match: "^pr-"
+    untagged: delete-unreferenced
+  - type: maven
+    match: "^org\\.example\\."
+    semver:
+      keep: 3
+`,
+		},
+		{
+			name:          "Empty policy is rejected",
+			policy:        ``,
+			expectedError: "policy is empty",
+		},
+		{
+			name: "Unknown fields are rejected",
+			policy: `
+owners:
+- organization: myorg
+  packages:
+  - type: container
+    keeplast: 3
+`,
+			expectedError: "line 6: field keeplast not found",
+		},
+		{
+			name: "Invalid regex is rejected",
+			policy: `
+owners:
+- organization: myorg
+  packages:
+  - type: container
+    version-match: "(["
+`,
+			expectedError: "line 6: invalid regex",
+		},
+		{
+			name: "Invalid duration is rejected",
+			policy: `
+defaults:
+  age: 90days
+owners:
+- organization: myorg
+  packages:
+  - type: container
+`,
+			expectedError: "line 3: invalid duration",
+		},
+		{
+			name: "Invalid untagged mode is rejected",
+			policy: `
+owners:
+- organization: myorg
+  packages:
+  - type: container
+    untagged: remove
+`,
+			expectedError: "line 6: invalid untagged mode",
+		},
+		{
+			name: "Package type is required",
+			policy: `
+owners:
+- organization: myorg
+  packages:
+  - names: [app]
+`,
+			expectedError: "line 5: package type must be given",
+		},
+		{
+			name: "Invalid package type is rejected",
+			policy: `
+owners:
+- organization: myorg
+  packages:
+  - names: [app]
+    type: containers
+`,
+			expectedError: "line 6: invalid package type \"containers\"",
+		},
+		{
+			name: "Organization and user are exclusive",
+			policy: `
+owners:
+- organization: myorg
+  user: myuser
+  packages:
+  - type: container
+`,
+			expectedError: "line 3: only one of organization and user can be given",
+		},
+		{
+			name: "Negative keep-last is rejected",
+			policy: `
+owners:
+- organization: myorg
+  defaults:
+    keep-last: -1
+  packages:
+  - type: container
+`,
+			expectedError: "line 5: keep-last must not be negative",
+		},
+		{
+			name: "At least one owner is required",
+			policy: `
+defaults:
+  age: 1h
+`,
+			expectedError: "line 2: at least one owner must be given",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(test.policy))
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorContains(t, err, test.expectedError)
+		})
+	}
+}
+
+func TestRulesMerge(t *testing.T) {
+	p, err := Load(strings.NewReader(`
+defaults:
+  age: 2160h
+  keep-last: 3
+  protect-match: "^latest$"
+owners:
+- organization: myorg
+  defaults:
+    keep-last: 5
+  packages:
+  - type: container
+    age: 24h
+`))
+
+	assert.NoError(t, err)
+
+	owner := p.Owners[0]
+	rules := owner.Packages[0].Rules.Merge(owner.Defaults.Merge(p.Defaults))
+	assert.Equal(t, 24*time.Hour, rules.Age.Duration)
+	assert.Equal(t, 5, *rules.KeepLast)
+	assert.Equal(t, "^latest$", rules.ProtectMatch.String())
+	assert.Nil(t, rules.VersionMatch)
+}
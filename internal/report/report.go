@@ -0,0 +1,151 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+)
+
+// Format is the output format of a report.
+type Format string
+
+const (
+	FormatJSON     Format = "json"
+	FormatCSV      Format = "csv"
+	FormatMarkdown Format = "markdown"
+)
+
+// ParseFormat validates a report format.
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case FormatJSON, FormatCSV, FormatMarkdown:
+		return Format(format), nil
+	default:
+		return "", fmt.Errorf("invalid report format %q, must be one of 'json', 'csv' or 'markdown'", format)
+	}
+}
+
+// Write writes a report with one entry per evaluated package version.
+// Entries are sorted by owner, package type, package and version ID so reports of different runs can be compared.
+func Write(w io.Writer, format Format, evaluations []*ghpackage.Evaluation) error {
+	evaluations = Sort(evaluations)
+
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, evaluations)
+	case FormatCSV:
+		return writeCSV(w, evaluations)
+	case FormatMarkdown:
+		return writeMarkdown(w, evaluations)
+	default:
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+}
+
+// Sort returns a copy of the evaluations sorted by owner, package type, package and version ID.
+func Sort(evaluations []*ghpackage.Evaluation) []*ghpackage.Evaluation {
+	sorted := append([]*ghpackage.Evaluation{}, evaluations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		}
+		if a.PackageType != b.PackageType {
+			return a.PackageType < b.PackageType
+		}
+		if a.PackageName != b.PackageName {
+			return a.PackageName < b.PackageName
+		}
+		return a.ID < b.ID
+	})
+
+	return sorted
+}
+
+// Read parses a json report.
+func Read(r io.Reader) ([]*ghpackage.Evaluation, error) {
+	var evaluations []*ghpackage.Evaluation
+	if err := json.NewDecoder(r).Decode(&evaluations); err != nil {
+		return nil, fmt.Errorf("failed to parse json report: %w", err)
+	}
+
+	return evaluations, nil
+}
+
+func writeJSON(w io.Writer, evaluations []*ghpackage.Evaluation) error {
+	if evaluations == nil {
+		evaluations = []*ghpackage.Evaluation{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(evaluations)
+}
+
+var header = []string{"owner", "package_type", "package", "version", "tags", "id", "updated_at", "decision", "rule", "error"}
+
+func writeCSV(w io.Writer, evaluations []*ghpackage.Evaluation) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, evaluation := range evaluations {
+		if err := writer.Write(row(evaluation)); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeMarkdown(w io.Writer, evaluations []*ghpackage.Evaluation) error {
+	if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | ")); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(header))); err != nil {
+		return err
+	}
+
+	for _, evaluation := range evaluations {
+		columns := row(evaluation)
+		for i, column := range columns {
+			columns[i] = strings.ReplaceAll(column, "|", "\\|")
+		}
+
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(columns, " | ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func row(evaluation *ghpackage.Evaluation) []string {
+	var updatedAt string
+	if !evaluation.UpdatedAt.IsZero() {
+		updatedAt = evaluation.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return []string{
+		evaluation.Owner,
+		evaluation.PackageType,
+		evaluation.PackageName,
+		evaluation.Version,
+		strings.Join(evaluation.Tags, ","),
+		strconv.FormatInt(evaluation.ID, 10),
+		updatedAt,
+		string(evaluation.Decision),
+		string(evaluation.Rule),
+		evaluation.Error,
+	}
+}
@@ -0,0 +1,126 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+	"github.com/stretchr/testify/assert"
+)
+
+var evaluations = []*ghpackage.Evaluation{
+	{
+		Owner:       "myorg",
+		OwnerType:   "organization",
+		PackageType: "container",
+		PackageName: "mypackage",
+		Version:     "sha256:b",
+		Tags:        []string{"v1.0.0", "latest"},
+		ID:          2,
+		UpdatedAt:   time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+		Decision:    ghpackage.DecisionKept,
+		Rule:        ghpackage.RuleProtectMatch,
+	},
+	{
+		Owner:       "myorg",
+		OwnerType:   "organization",
+		PackageType: "container",
+		PackageName: "mypackage",
+		Version:     "sha256:a",
+		Tags:        []string{"pr-1"},
+		ID:          1,
+		UpdatedAt:   time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Decision:    ghpackage.DecisionError,
+		Rule:        ghpackage.RuleAge,
+		Error:       "forbidden | no access",
+	},
+}
+
+func TestWrite(t *testing.T) {
+	var tests = []struct {
+		format   Format
+		expected string
+	}{
+		{
+			format: FormatJSON,
+			expected: `[
+  {
+    "owner": "myorg",
+    "ownerType": "organization",
+    "packageType": "container",
+    "package": "mypackage",
+    "version": "sha256:a",
+    "tags": [
+      "pr-1"
+    ],
+    "id": 1,
+    "updatedAt": "2023-01-01T00:00:00Z",
+    "decision": "error",
+    "rule": "age",
+    "error": "forbidden | no access"
+  },
+  {
+    "owner": "myorg",
+    "ownerType": "organization",
+    "packageType": "container",
+    "package": "mypackage",
+    "version": "sha256:b",
+    "tags": [
+      "v1.0.0",
+      "latest"
+    ],
+    "id": 2,
+    "updatedAt": "2023-01-02T00:00:00Z",
+    "decision": "kept",
+    "rule": "protect-match"
+  }
+]
+`,
+		},
+		{
+			format: FormatCSV,
+			expected: `owner,package_type,package,version,tags,id,updated_at,decision,rule,error
+myorg,container,mypackage,sha256:a,pr-1,1,2023-01-01T00:00:00Z,error,age,forbidden | no access
+myorg,container,mypackage,sha256:b,"v1.0.0,latest",2,2023-01-02T00:00:00Z,kept,protect-match,
+`,
+		},
+		{
+			format: FormatMarkdown,
+			expected: `| owner | package_type | package | version | tags | id | updated_at | decision | rule | error |
+| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |
+| myorg | container | mypackage | sha256:a | pr-1 | 1 | 2023-01-01T00:00:00Z | error | age | forbidden \| no access |
+| myorg | container | mypackage | sha256:b | v1.0.0,latest | 2 | 2023-01-02T00:00:00Z | kept | protect-match |  |
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			var b bytes.Buffer
+			assert.NoError(t, Write(&b, test.format, evaluations))
+			assert.Equal(t, test.expected, b.String())
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("csv")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatCSV, format)
+
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestRead(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, Write(&b, FormatJSON, evaluations))
+
+	read, err := Read(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, Sort(evaluations), read)
+
+	_, err = Read(bytes.NewBufferString("id,version"))
+	assert.Error(t, err)
+}
@@ -3,15 +3,25 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/doodlescheduling/gh-package-retention/internal/actions"
+	"github.com/doodlescheduling/gh-package-retention/internal/ghapp"
 	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+	"github.com/doodlescheduling/gh-package-retention/internal/inuse"
+	"github.com/doodlescheduling/gh-package-retention/internal/kube"
+	"github.com/doodlescheduling/gh-package-retention/internal/report"
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-github/v53/github"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sethvargo/go-envconfig"
 	flag "github.com/spf13/pflag"
 	"go.uber.org/zap"
@@ -19,18 +29,62 @@ import (
 )
 
 type Config struct {
-	Yes bool `env:"YES"`
-	Log struct {
+	Yes        bool   `env:"YES"`
+	Config     string `env:"CONFIG"`
+	FromReport string `env:"FROM_REPORT"`
+	PlanFile   string `env:"PLAN_FILE"`
+	Log        struct {
 		Level    string `env:"LOG_LEVEL"`
 		Encoding string `env:"LOG_ENCODING"`
 	}
-	VersionMatch string        `env:"VERSION_MATCH"`
-	PackageType  string        `env:"PACKAGE_TYPE"`
-	MaxVersions  int           `env:"MAX_VERSIONS"`
-	Packages     []string      `env:"PACKAGES"`
-	Token        string        `env:"GITHUB_TOKEN"`
-	Age          time.Duration `env:"AGE"`
-	OrgName      string        `env:"ORG_NAME"`
+	VersionMatch        string   `env:"VERSION_MATCH"`
+	ProtectMatch        string   `env:"PROTECT_MATCH"`
+	InUseFrom           []string `env:"IN_USE_FROM"`
+	PackageType         string   `env:"PACKAGE_TYPE"`
+	MaxVersions         int      `env:"MAX_VERSIONS"`
+	MaxRetries          int      `env:"MAX_RETRIES"`
+	ContinueOnError     bool     `env:"CONTINUE_ON_ERROR"`
+	MaxDeletions        int      `env:"MAX_DELETIONS"`
+	MaxPackageDeletions int      `env:"MAX_PACKAGE_DELETIONS"`
+	MaxDeletePercent    float64  `env:"MAX_DELETE_PERCENT"`
+	Force               bool     `env:"FORCE"`
+	Concurrency         int      `env:"CONCURRENCY"`
+	Packages            []string `env:"PACKAGES"`
+	AllPackages         bool     `env:"ALL_PACKAGES"`
+	PackageMatch        string   `env:"PACKAGE_MATCH"`
+	Repository          string   `env:"REPOSITORY"`
+	Token               string   `env:"GITHUB_TOKEN"`
+	GithubURL           string   `env:"GITHUB_URL"`
+	RegistryHost        string   `env:"REGISTRY_HOST"`
+	App                 struct {
+		ID             int64  `env:"APP_ID"`
+		InstallationID int64  `env:"INSTALLATION_ID"`
+		PrivateKeyFile string `env:"PRIVATE_KEY_FILE"`
+	}
+	Metrics struct {
+		Addr           string        `env:"METRICS_ADDR"`
+		Interval       time.Duration `env:"INTERVAL"`
+		PushgatewayURL string        `env:"PUSHGATEWAY_URL"`
+	}
+	Kube struct {
+		Config            string   `env:"IN_USE_KUBECONFIG"`
+		Namespaces        []string `env:"IN_USE_NAMESPACES"`
+		NamespaceSelector string   `env:"IN_USE_NAMESPACE_SELECTOR"`
+	}
+	Age        time.Duration `env:"AGE"`
+	OrgName    string        `env:"ORG_NAME"`
+	User       string        `env:"USER_NAME"`
+	KeepLast   int           `env:"KEEP_LAST"`
+	Untagged   string        `env:"UNTAGGED"`
+	Companions bool          `env:"COMPANION_ARTIFACTS"`
+	Report     struct {
+		Format string `env:"REPORT_FORMAT"`
+		File   string `env:"REPORT_FILE"`
+	}
+	Semver struct {
+		Keep           int `env:"SEMVER_KEEP"`
+		KeepPrerelease int `env:"SEMVER_KEEP_PRERELEASE"`
+	}
 }
 
 var (
@@ -45,12 +99,46 @@ func must(err error) {
 
 func init() {
 	flag.BoolVar(&config.Yes, "yes", false, "Skip dry-run and delete packages")
+	flag.StringVar(&config.FromReport, "from-report", "", "Path to a json run report. The restore command restores all package versions which have been deleted according to the report.")
+	flag.StringVar(&config.PlanFile, "plan-file", "plan.json", "Path to the plan file which is written by the plan command and read by the apply command.")
+	flag.StringVar(&config.Config, "config", "", "Path to a retention policy file. Owners, packages and rules are taken from the policy instead of the flags.")
 	flag.StringVar(&config.VersionMatch, "version-match", "", "Version match")
+	flag.StringVar(&config.ProtectMatch, "protect-match", "", "Regex to protect versions. A version (or any of its container tags) which matches is never removed, regardless of any other rule.")
+	flag.StringSliceVar(&config.InUseFrom, "in-use-from", nil, "Kubernetes manifests, Helm charts, Kustomize overlays or Dockerfiles (files or directories) to scan for image references. Container versions which are in use, including the manifests of in-use image indexes, are never removed.")
+	flag.StringVar(&config.Kube.Config, "kubeconfig", "", "Path to a kubeconfig. Container versions used by Pods, Deployments, StatefulSets, DaemonSets and CronJobs of the cluster are never removed.")
+	flag.StringSliceVar(&config.Kube.Namespaces, "namespaces", nil, "Namespaces of the cluster to query for images in use (Requires kubeconfig). By default all namespaces are queried.")
+	flag.StringVar(&config.Kube.NamespaceSelector, "namespace-selector", "", "Label selector for the namespaces of the cluster to query for images in use (Requires kubeconfig).")
 	flag.DurationVar(&config.Age, "age", 0, "Max age of a package version. Package versions older than the specified age will be removed (As long as version-match matches the version).")
 	flag.StringVar(&config.OrgName, "org-name", "", "Github organization name which is the package owner")
-	flag.IntVar(&config.MaxVersions, "max-versions", 1000, "Limit number of versions to process.")
+	flag.StringVar(&config.User, "user", "", "Github user name which is the package owner. If neither org-name nor user is given the packages of the authenticated user are used.")
+	flag.IntVar(&config.MaxVersions, "max-versions", 1000, "Limit number of package versions which are deleted per run (0 means no limit). The oldest versions are deleted first, the remaining ones are deleted by the next run.")
+	flag.IntVar(&config.Concurrency, "concurrency", 1, "Number of packages which are evaluated and package versions which are deleted concurrently.")
+	flag.BoolVar(&config.ContinueOnError, "continue-on-error", false, "Continue with all other packages and package versions if a package or a package version fails. All failures are reported at the end and the process exits non-zero.")
+	flag.IntVar(&config.MaxDeletions, "max-deletions", 0, "Abort the run before deleting anything if more package versions would be deleted in total (0 means no limit).")
+	flag.IntVar(&config.MaxPackageDeletions, "max-package-deletions", 0, "Skip a package with an error if more of its versions would be deleted (0 means no limit).")
+	flag.Float64Var(&config.MaxDeletePercent, "max-delete-percent", 0, "Skip a package with an error if a larger share of its versions (in percent) would be deleted (0 means no limit).")
+	flag.BoolVar(&config.Force, "force", false, "Ignore max-deletions, max-package-deletions and max-delete-percent.")
+	flag.IntVar(&config.MaxRetries, "max-retries", 5, "Number of retries for rate limited or transiently failing GitHub and registry requests.")
+	flag.IntVar(&config.KeepLast, "keep-last", 0, "Always keep the N newest package versions (matching version-match) per package.")
+	flag.IntVar(&config.Semver.Keep, "semver-keep", 0, "Enable the semver policy and keep the N newest releases of every major/minor version. Versions which are not semver are never removed.")
+	flag.IntVar(&config.Semver.KeepPrerelease, "semver-keep-prerelease", 0, "Number of pre-releases to keep per major/minor version if the semver policy is enabled.")
+	flag.StringVar(&config.Untagged, "untagged", "", "How to handle untagged container versions. Can be one of 'keep', 'delete' or 'delete-unreferenced'. By default untagged versions are only removed if version-match is not set.")
+	flag.BoolVar(&config.Companions, "companion-artifacts", false, "Remove cosign signatures, attestations and SBOMs (and OCI referrers) together with their image and keep them as long as their image exists.")
 	flag.StringVar(&config.Token, "token", "", "Github token (By default GITHUB_TOKEN will be used)")
+	flag.StringVar(&config.GithubURL, "github-url", "", "Github Enterprise Server URL (By default GITHUB_API_URL will be used within Github Actions, otherwise github.com).")
+	flag.StringVar(&config.RegistryHost, "registry-host", "", "Container registry host (By default ghcr.io or containers.<host> for Github Enterprise Server).")
+	flag.Int64Var(&config.App.ID, "app-id", 0, "Github App ID. Authenticate as Github App installation instead of using a token (Requires installation-id and private-key-file).")
+	flag.Int64Var(&config.App.InstallationID, "installation-id", 0, "Github App installation ID.")
+	flag.StringVar(&config.App.PrivateKeyFile, "private-key-file", "", "Path to the PEM encoded private key of the Github App.")
+	flag.BoolVar(&config.AllPackages, "all-packages", false, "Discover all packages of the given package type owned by the package owner.")
+	flag.StringVar(&config.PackageMatch, "package-match", "", "Regex to filter discovered packages by name (Requires all-packages).")
+	flag.StringVar(&config.Repository, "repository", "", "Only discover packages linked to the given repository (Requires all-packages).")
 	flag.StringVar(&config.PackageType, "package-type", "", "Type of package (container, maven, ...)")
+	flag.StringVar(&config.Report.Format, "report-format", "", "Write a report of all evaluated package versions. Can be one of 'json', 'csv' or 'markdown'.")
+	flag.StringVar(&config.Report.File, "report-file", "", "Path to write the report to (By default the report is written to stdout).")
+	flag.StringVar(&config.Metrics.Addr, "metrics-addr", "", "Run as daemon which runs the retention every interval and exposes prometheus metrics on the given address (e.g. :9556).")
+	flag.DurationVar(&config.Metrics.Interval, "interval", 24*time.Hour, "Interval between retention runs in daemon mode (Requires metrics-addr).")
+	flag.StringVar(&config.Metrics.PushgatewayURL, "pushgateway-url", "", "Push prometheus metrics to the given Pushgateway at the end of the run.")
 	flag.StringVar(&config.Log.Encoding, "log-encoding", "console", "Log encoding format. Can be 'json' or 'console'.")
 	flag.StringVar(&config.Log.Level, "log-level", "info", "Log verbosity level. Can be one of 'trace', 'debug', 'info', 'error'.")
 }
@@ -66,56 +154,405 @@ func main() {
 	logger, err := buildLogger()
 	must(err)
 
-	if len(flag.Args()) > 0 {
-		config.Packages = flag.Args()
-	}
+	must(resolveHosts())
 
-	if len(config.Packages) == 0 {
-		must(errors.New("at least one package name must be given"))
-	}
+	registry, metrics := newMetrics()
 
-	var versionMatchRegexp *regexp.Regexp
-	if config.VersionMatch != "" {
-		r, err := regexp.Compile(config.VersionMatch)
-		must(err)
-		versionMatchRegexp = r
-	}
-
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: config.Token},
-	)
+	ts, err := tokenSource(logger, metrics)
+	must(err)
 
 	tc := oauth2.NewClient(ctx, ts)
 	tc.Transport = &loggingRoundTripper{
-		next:   tc.Transport,
-		logger: logger,
+		next:    tc.Transport,
+		logger:  logger,
+		metrics: metrics,
 	}
 
 	containerTransport := &loggingRoundTripper{
-		next:   http.DefaultTransport,
-		logger: logger,
+		next:    http.DefaultTransport,
+		logger:  logger,
+		metrics: metrics,
 	}
 
 	ghClient := github.NewClient(tc)
+	if config.GithubURL != "" {
+		ghClient, err = github.NewEnterpriseClient(config.GithubURL, config.GithubURL, tc)
+		must(err)
+	}
 
-	a := ghpackage.RetentionManager{
+	base := ghpackage.RetentionManager{
 		ContainerRegistryTransport: containerTransport,
-		PackageType:                strings.ToLower(config.PackageType),
+		RegistryHost:               config.RegistryHost,
 		Token:                      config.Token,
+		TokenSource:                ts,
 		DryRun:                     !config.Yes,
 		MaxVersions:                config.MaxVersions,
+		MaxRetries:                 config.MaxRetries,
+		Concurrency:                config.Concurrency,
+		ContinueOnError:            config.ContinueOnError,
+		MaxDeletions:               config.MaxDeletions,
+		MaxPackageDeletions:        config.MaxPackageDeletions,
+		MaxDeletePercent:           config.MaxDeletePercent,
+		Force:                      config.Force,
 		GithubClient:               ghClient,
-		PackageNames:               config.Packages,
-		Age:                        config.Age,
-		OrganizationName:           strings.ToLower(config.OrgName),
-		VersionMatch:               versionMatchRegexp,
 		Logger:                     logger,
+		Metrics:                    metrics,
+	}
+
+	err = run(ctx, base, registry, flag.Args())
+	if config.Metrics.PushgatewayURL != "" {
+		must(pushMetrics(registry))
 	}
 
-	_, err = a.Run(ctx)
 	must(err)
 }
 
+// run runs either one of the commands or a package retention.
+func run(ctx context.Context, base ghpackage.RetentionManager, registry *prometheus.Registry, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "restore":
+			return restore(ctx, base, args[1:])
+		case "plan":
+			inUse, err := inUse(ctx, base.Logger)
+			if err != nil {
+				return err
+			}
+
+			base.InUse = inUse
+			return plan(ctx, base, args[1:])
+		case "apply":
+			return apply(ctx, base, args[1:])
+		}
+
+		config.Packages = args
+	}
+
+	if config.Metrics.Addr != "" {
+		return daemon(ctx, base, registry)
+	}
+
+	return retainInUse(ctx, base)
+}
+
+// retainInUse runs the package retention protecting the images which are currently in use.
+func retainInUse(ctx context.Context, base ghpackage.RetentionManager) error {
+	inUse, err := inUse(ctx, base.Logger)
+	if err != nil {
+		return err
+	}
+
+	base.InUse = inUse
+	_, err = retain(ctx, base)
+	return err
+}
+
+// inUse returns the image references found in the in-use sources and the cluster.
+func inUse(ctx context.Context, logger logr.Logger) ([]name.Reference, error) {
+	var refs []name.Reference
+	if len(config.InUseFrom) > 0 {
+		found, err := inuse.FromPaths(config.InUseFrom...)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Info("found image references which are in use", "count", len(found))
+		refs = append(refs, found...)
+	}
+
+	if config.Kube.Config != "" {
+		found, err := clusterImages(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Info("found image references which are in use by the cluster", "count", len(found))
+		refs = append(refs, found...)
+	}
+
+	return refs, nil
+}
+
+// retain runs the package retention for either the policy config or the flags
+// and returns the evaluations of all managers.
+func retain(ctx context.Context, base ghpackage.RetentionManager) ([]*ghpackage.Evaluation, error) {
+	var managers []*ghpackage.RetentionManager
+	if config.Config != "" {
+		if len(config.Packages) > 0 {
+			return nil, errors.New("package names can not be given together with a policy config")
+		}
+
+		m, err := managersFromPolicy(config.Config, base)
+		if err != nil {
+			return nil, err
+		}
+
+		managers = m
+	} else {
+		a, err := managerFromFlags(base)
+		if err != nil {
+			return nil, err
+		}
+
+		managers = append(managers, a)
+	}
+
+	reportFormat, err := parseReportFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	// All managers are evaluated before anything is deleted, hence max-deletions covers the whole run
+	removed, failures := ghpackage.RunAll(ctx, &base, managers, func(a *ghpackage.RetentionManager) ([]*ghpackage.PackageVersion, error) {
+		return a.Evaluate(ctx)
+	})
+
+	var evaluations []*ghpackage.Evaluation
+	for _, a := range managers {
+		evaluations = append(evaluations, a.Evaluations()...)
+	}
+
+	if err := publish(reportFormat, base.DryRun, evaluations); err != nil {
+		return evaluations, err
+	}
+
+	if err := failed(base, failures); err != nil {
+		return evaluations, err
+	}
+
+	base.Logger.Info("package retention finished", "removed", len(removed), "dry-run", base.DryRun)
+	return evaluations, nil
+}
+
+func parseReportFormat() (report.Format, error) {
+	if config.Report.Format == "" {
+		return "", nil
+	}
+
+	return report.ParseFormat(config.Report.Format)
+}
+
+// publish writes the report and, if running within Github Actions, the job summary and step outputs.
+func publish(reportFormat report.Format, dryRun bool, evaluations []*ghpackage.Evaluation) error {
+	if reportFormat != "" {
+		if err := writeReport(reportFormat, evaluations); err != nil {
+			return err
+		}
+	}
+
+	if actions.Enabled() {
+		if err := actions.Publish(dryRun, evaluations); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failed returns the first failure or, in continue-on-error mode, logs and returns all failures.
+func failed(base ghpackage.RetentionManager, failures []error) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	if !config.ContinueOnError {
+		return failures[0]
+	}
+
+	failures = unwrapFailures(failures)
+	for _, err := range failures {
+		base.Logger.Error(err, "package retention failure")
+	}
+
+	return fmt.Errorf("package retention finished with %d failures: %w", len(failures), errors.Join(failures...))
+}
+
+// unwrapFailures flattens joined errors into the individual failures.
+func unwrapFailures(errs []error) []error {
+	var failures []error
+	for _, err := range errs {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			failures = append(failures, unwrapFailures(joined.Unwrap())...)
+			continue
+		}
+
+		failures = append(failures, err)
+	}
+
+	return failures
+}
+
+func writeReport(format report.Format, evaluations []*ghpackage.Evaluation) error {
+	if config.Report.File == "" {
+		return report.Write(os.Stdout, format, evaluations)
+	}
+
+	f, err := os.Create(config.Report.File)
+	if err != nil {
+		return err
+	}
+
+	if err := report.Write(f, format, evaluations); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func managerFromFlags(base ghpackage.RetentionManager) (*ghpackage.RetentionManager, error) {
+	if len(config.Packages) == 0 && !config.AllPackages {
+		return nil, errors.New("at least one package name must be given")
+	}
+
+	if (config.PackageMatch != "" || config.Repository != "") && !config.AllPackages {
+		return nil, errors.New("package-match and repository require all-packages")
+	}
+
+	if config.OrgName != "" && config.User != "" {
+		return nil, errors.New("only one of org-name and user can be given")
+	}
+
+	switch ghpackage.UntaggedMode(config.Untagged) {
+	case "", ghpackage.UntaggedKeep, ghpackage.UntaggedDelete, ghpackage.UntaggedDeleteUnreferenced:
+	default:
+		return nil, fmt.Errorf("invalid untagged mode %q given, must be one of 'keep', 'delete' or 'delete-unreferenced'", config.Untagged)
+	}
+
+	var versionMatchRegexp *regexp.Regexp
+	if config.VersionMatch != "" {
+		r, err := regexp.Compile(config.VersionMatch)
+		if err != nil {
+			return nil, err
+		}
+		versionMatchRegexp = r
+	}
+
+	var packageMatchRegexp *regexp.Regexp
+	if config.PackageMatch != "" {
+		r, err := regexp.Compile(config.PackageMatch)
+		if err != nil {
+			return nil, err
+		}
+		packageMatchRegexp = r
+	}
+
+	var protectMatchRegexp *regexp.Regexp
+	if config.ProtectMatch != "" {
+		r, err := regexp.Compile(config.ProtectMatch)
+		if err != nil {
+			return nil, err
+		}
+		protectMatchRegexp = r
+	}
+
+	var semverPolicy *ghpackage.SemverPolicy
+	if config.Semver.Keep > 0 {
+		semverPolicy = &ghpackage.SemverPolicy{
+			Keep:           config.Semver.Keep,
+			KeepPrerelease: config.Semver.KeepPrerelease,
+		}
+	}
+
+	a := base
+	a.PackageType = strings.ToLower(config.PackageType)
+	a.KeepLast = config.KeepLast
+	a.Semver = semverPolicy
+	a.Untagged = ghpackage.UntaggedMode(config.Untagged)
+	a.CompanionArtifacts = config.Companions
+	a.PackageNames = config.Packages
+	a.AllPackages = config.AllPackages
+	a.PackageMatch = packageMatchRegexp
+	a.Repository = config.Repository
+	a.Age = config.Age
+	a.OrganizationName = strings.ToLower(config.OrgName)
+	a.UserName = strings.ToLower(config.User)
+	a.VersionMatch = versionMatchRegexp
+	a.ProtectMatch = protectMatchRegexp
+
+	return &a, nil
+}
+
+// clusterImages returns the image references used by the workloads of the cluster of the kubeconfig.
+func clusterImages(ctx context.Context) ([]name.Reference, error) {
+	client, err := kube.NewClient(config.Kube.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return kube.Images(ctx, client, kube.NamespaceSelector{
+		Names:         config.Kube.Namespaces,
+		LabelSelector: config.Kube.NamespaceSelector,
+	})
+}
+
+// resolveHosts derives the Github Enterprise Server URL and the container registry host if they are not configured.
+// Within Github Actions GITHUB_API_URL and GITHUB_SERVER_URL point to the Github instance the workflow runs on.
+func resolveHosts() error {
+	if config.GithubURL == "" {
+		if apiURL := os.Getenv("GITHUB_API_URL"); apiURL != "" && apiURL != "https://api.github.com" {
+			config.GithubURL = apiURL
+		}
+	}
+
+	if config.RegistryHost != "" {
+		return nil
+	}
+
+	config.RegistryHost = ghpackage.DefaultRegistryHost
+	serverURL := config.GithubURL
+	if serverURL == "" {
+		serverURL = os.Getenv("GITHUB_SERVER_URL")
+	}
+
+	if serverURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid github url %q: %w", serverURL, err)
+	}
+
+	switch u.Hostname() {
+	case "", "github.com", "api.github.com":
+	default:
+		config.RegistryHost = "containers." + u.Hostname()
+	}
+
+	return nil
+}
+
+// tokenSource returns either a source of auto refreshed Github App installation tokens or the static token.
+func tokenSource(logger logr.Logger, metrics *ghpackage.Metrics) (oauth2.TokenSource, error) {
+	if config.App.ID == 0 && config.App.InstallationID == 0 && config.App.PrivateKeyFile == "" {
+		return oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: config.Token},
+		), nil
+	}
+
+	if config.App.ID == 0 || config.App.InstallationID == 0 || config.App.PrivateKeyFile == "" {
+		return nil, errors.New("app-id, installation-id and private-key-file must be given together")
+	}
+
+	privateKey, err := os.ReadFile(config.App.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return ghapp.Config{
+		BaseURL:        config.GithubURL,
+		AppID:          config.App.ID,
+		InstallationID: config.App.InstallationID,
+		PrivateKey:     privateKey,
+		Transport: &loggingRoundTripper{
+			next:    http.DefaultTransport,
+			logger:  logger,
+			metrics: metrics,
+		},
+	}.TokenSource()
+}
+
 func buildLogger() (logr.Logger, error) {
 	logOpts := zap.NewDevelopmentConfig()
 	logOpts.Encoding = config.Log.Encoding
@@ -134,13 +571,22 @@ func buildLogger() (logr.Logger, error) {
 }
 
 type loggingRoundTripper struct {
-	logger logr.Logger
-	next   http.RoundTripper
+	logger  logr.Logger
+	next    http.RoundTripper
+	metrics *ghpackage.Metrics
 }
 
 func (p loggingRoundTripper) RoundTrip(req *http.Request) (res *http.Response, e error) {
 	p.logger.V(1).Info("http request sent", "method", req.Method, "uri", req.URL.String())
+	start := time.Now()
 	res, err := p.next.RoundTrip(req)
-	p.logger.V(1).Info("http response received", "method", req.Method, "uri", req.URL.String(), "status", res.StatusCode, "err", err)
+	p.metrics.ObserveRequest(req, res, time.Since(start))
+
+	if err != nil {
+		p.logger.V(1).Info("http request failed", "method", req.Method, "uri", req.URL.String(), "err", err)
+		return res, err
+	}
+
+	p.logger.V(1).Info("http response received", "method", req.Method, "uri", req.URL.String(), "status", res.StatusCode)
 	return res, err
 }
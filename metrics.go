@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushgatewayJob is the job name of the metrics pushed to a Pushgateway.
+const pushgatewayJob = "gh_package_retention"
+
+// newMetrics returns the registry and the retention metrics if either the daemon mode or a Pushgateway is configured.
+func newMetrics() (*prometheus.Registry, *ghpackage.Metrics) {
+	if config.Metrics.Addr == "" && config.Metrics.PushgatewayURL == "" {
+		return nil, nil
+	}
+
+	registry := prometheus.NewRegistry()
+	if config.Metrics.Addr != "" {
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+
+	return registry, ghpackage.NewMetrics(registry)
+}
+
+// pushMetrics pushes all metrics of the registry to the Pushgateway.
+func pushMetrics(registry *prometheus.Registry) error {
+	return push.New(config.Metrics.PushgatewayURL, pushgatewayJob).Gatherer(registry).Push()
+}
+
+// daemon exposes the metrics and runs the package retention every interval until the process is terminated.
+// A failed run is logged and retried with the next interval.
+func daemon(ctx context.Context, base ghpackage.RetentionManager, registry *prometheus.Registry) error {
+	if config.Metrics.Interval <= 0 {
+		return errors.New("interval must be greater than 0")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Listen before the first run so an address which is already in use fails immediately
+	listener, err := net.Listen("tcp", config.Metrics.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", config.Metrics.Addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	base.Logger.Info("metrics server started", "addr", listener.Addr().String(), "interval", config.Metrics.Interval)
+	ticker := time.NewTicker(config.Metrics.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := retainInUse(ctx, base); err != nil {
+			base.Logger.Error(err, "retention run failed")
+		}
+
+		select {
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return server.Shutdown(shutdownCtx)
+		case <-ticker.C:
+		}
+	}
+}
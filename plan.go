@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+)
+
+// plan runs the package retention in dry-run mode and writes all elected package versions to the plan file.
+func plan(ctx context.Context, base ghpackage.RetentionManager, args []string) error {
+	if len(args) > 0 {
+		config.Packages = args
+	}
+
+	base.DryRun = true
+	evaluations, err := retain(ctx, base)
+	if err != nil {
+		return err
+	}
+
+	p := ghpackage.NewPlan(evaluations)
+
+	f, err := os.Create(config.PlanFile)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	base.Logger.Info("plan written", "file", config.PlanFile, "versions", len(p.Versions))
+	return nil
+}
+
+// apply deletes the package versions of the plan file.
+func apply(ctx context.Context, base ghpackage.RetentionManager, args []string) error {
+	if len(args) > 0 {
+		return errors.New("apply does not accept any arguments, the package versions are taken from the plan file")
+	}
+
+	reportFormat, err := parseReportFormat()
+	if err != nil {
+		return err
+	}
+
+	p, err := readPlan(config.PlanFile)
+	if err != nil {
+		return err
+	}
+
+	targets := make(map[packageOwner][]*ghpackage.PlannedVersion)
+	var order []packageOwner
+
+	for _, planned := range p.Versions {
+		target := packageOwner{
+			ownerType:   planned.OwnerType,
+			owner:       planned.Owner,
+			packageType: planned.PackageType,
+		}
+
+		if _, ok := targets[target]; !ok {
+			order = append(order, target)
+		}
+
+		targets[target] = append(targets[target], planned)
+	}
+
+	managers := make([]*ghpackage.RetentionManager, len(order))
+	planned := make(map[*ghpackage.RetentionManager][]*ghpackage.PlannedVersion, len(order))
+	for i, target := range order {
+		a, err := target.manager(base)
+		if err != nil {
+			return err
+		}
+
+		managers[i] = a
+		planned[a] = targets[target]
+	}
+
+	// All owners are verified before anything is deleted, hence max-deletions covers the whole plan
+	deleted, failures := ghpackage.RunAll(ctx, &base, managers, func(a *ghpackage.RetentionManager) ([]*ghpackage.PackageVersion, error) {
+		return a.Verify(ctx, planned[a])
+	})
+
+	var evaluations []*ghpackage.Evaluation
+	for _, a := range managers {
+		evaluations = append(evaluations, a.Evaluations()...)
+	}
+
+	if err := publish(reportFormat, base.DryRun, evaluations); err != nil {
+		return err
+	}
+
+	if err := failed(base, failures); err != nil {
+		return err
+	}
+
+	base.Logger.Info("plan applied", "planned", len(p.Versions), "removed", len(deleted), "dry-run", base.DryRun)
+	return nil
+}
+
+func readPlan(path string) (*ghpackage.Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ghpackage.ReadPlan(f)
+}
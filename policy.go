@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+	"github.com/doodlescheduling/gh-package-retention/internal/policy"
+)
+
+// managersFromPolicy expands a policy file into one RetentionManager per owner and package selector.
+func managersFromPolicy(path string, base ghpackage.RetentionManager) ([]*ghpackage.RetentionManager, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	p, err := policy.Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+
+	var managers []*ghpackage.RetentionManager
+	for _, owner := range p.Owners {
+		defaults := owner.Defaults.Merge(p.Defaults)
+
+		for _, pkg := range owner.Packages {
+			rules := pkg.Rules.Merge(defaults)
+
+			a := base
+			a.OrganizationName = strings.ToLower(owner.Organization)
+			a.UserName = strings.ToLower(owner.User)
+			a.PackageType = strings.ToLower(pkg.Type)
+			a.PackageNames = pkg.Names
+			a.AllPackages = len(pkg.Names) == 0
+			a.Repository = pkg.Repository
+
+			if pkg.Match != nil {
+				a.PackageMatch = pkg.Match.Regexp
+			}
+
+			if rules.Age != nil {
+				a.Age = rules.Age.Duration
+			}
+
+			if rules.KeepLast != nil {
+				a.KeepLast = *rules.KeepLast
+			}
+
+			if rules.VersionMatch != nil {
+				a.VersionMatch = rules.VersionMatch.Regexp
+			}
+
+			if rules.ProtectMatch != nil {
+				a.ProtectMatch = rules.ProtectMatch.Regexp
+			}
+
+			if rules.Untagged != nil {
+				a.Untagged = ghpackage.UntaggedMode(*rules.Untagged)
+			}
+
+			if rules.Semver != nil {
+				a.Semver = &ghpackage.SemverPolicy{
+					Keep:           rules.Semver.Keep,
+					KeepPrerelease: rules.Semver.KeepPrerelease,
+				}
+			}
+
+			if rules.CompanionArtifacts != nil {
+				a.CompanionArtifacts = *rules.CompanionArtifacts
+			}
+
+			managers = append(managers, &a)
+		}
+	}
+
+	return managers, nil
+}
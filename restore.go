@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/doodlescheduling/gh-package-retention/internal/ghpackage"
+	"github.com/doodlescheduling/gh-package-retention/internal/report"
+)
+
+type packageOwner struct {
+	ownerType   string
+	owner       string
+	packageType string
+}
+
+// restore restores deleted package versions either from a json run report
+// or from explicit <package>:<version id> arguments.
+func restore(ctx context.Context, base ghpackage.RetentionManager, args []string) error {
+	targets := make(map[packageOwner][]*ghpackage.PackageVersion)
+	var order []packageOwner
+
+	add := func(target packageOwner, packageVersion *ghpackage.PackageVersion) {
+		if _, ok := targets[target]; !ok {
+			order = append(order, target)
+		}
+
+		targets[target] = append(targets[target], packageVersion)
+	}
+
+	switch {
+	case config.FromReport != "" && len(args) > 0:
+		return errors.New("package versions can not be given together with from-report")
+	case config.FromReport != "":
+		evaluations, err := readReport(config.FromReport)
+		if err != nil {
+			return err
+		}
+
+		for _, evaluation := range evaluations {
+			if evaluation.Decision != ghpackage.DecisionDeleted {
+				continue
+			}
+
+			add(packageOwner{
+				ownerType:   evaluation.OwnerType,
+				owner:       evaluation.Owner,
+				packageType: evaluation.PackageType,
+			}, &ghpackage.PackageVersion{
+				PackageName: evaluation.PackageName,
+				Version:     evaluation.Version,
+				ID:          evaluation.ID,
+			})
+		}
+	case len(args) > 0:
+		if config.PackageType == "" {
+			return errors.New("package-type must be given")
+		}
+
+		if config.OrgName != "" && config.User != "" {
+			return errors.New("only one of org-name and user can be given")
+		}
+
+		target := packageOwner{
+			ownerType:   ghpackage.OwnerTypeOrganization,
+			owner:       config.OrgName,
+			packageType: config.PackageType,
+		}
+
+		if config.OrgName == "" {
+			target.ownerType = ghpackage.OwnerTypeUser
+			target.owner = config.User
+		}
+
+		for _, arg := range args {
+			packageVersion, err := parsePackageVersion(arg)
+			if err != nil {
+				return err
+			}
+
+			add(target, packageVersion)
+		}
+	default:
+		return errors.New("either from-report or at least one <package>:<version id> must be given")
+	}
+
+	var restored []*ghpackage.PackageVersion
+	for _, target := range order {
+		a, err := target.manager(base)
+		if err != nil {
+			return err
+		}
+
+		r, err := a.Restore(ctx, targets[target])
+		restored = append(restored, r...)
+		if err != nil {
+			return err
+		}
+	}
+
+	base.Logger.Info("package restore finished", "restored", len(restored), "dry-run", !config.Yes)
+	return nil
+}
+
+// manager returns a copy of the base manager for the package owner.
+func (o packageOwner) manager(base ghpackage.RetentionManager) (*ghpackage.RetentionManager, error) {
+	a := base
+	a.PackageType = strings.ToLower(o.packageType)
+
+	switch o.ownerType {
+	case ghpackage.OwnerTypeOrganization:
+		a.OrganizationName = strings.ToLower(o.owner)
+	case ghpackage.OwnerTypeUser:
+		a.UserName = strings.ToLower(o.owner)
+	default:
+		return nil, fmt.Errorf("unknown owner type %q", o.ownerType)
+	}
+
+	return &a, nil
+}
+
+// parsePackageVersion parses a <package>:<version id> argument.
+func parsePackageVersion(arg string) (*ghpackage.PackageVersion, error) {
+	i := strings.LastIndex(arg, ":")
+	if i < 1 {
+		return nil, fmt.Errorf("invalid package version %q, must be <package>:<version id>", arg)
+	}
+
+	id, err := strconv.ParseInt(arg[i+1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid package version %q, must be <package>:<version id>", arg)
+	}
+
+	return &ghpackage.PackageVersion{
+		PackageName: arg[:i],
+		ID:          id,
+	}, nil
+}
+
+func readReport(path string) ([]*ghpackage.Evaluation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return report.Read(f)
+}